@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
+)
+
+// apiError is an error reported with its HTTP status code, e.g. a message
+// rejected by the validator in strict mode. It is encoded as the body of the
+// responses of the API.
+type apiError struct {
+	Status  int               `json:"-"`
+	Message string            `json:"error"`
+	Issues  []validationIssue `json:"issues,omitempty"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, format string, a ...interface{}) *apiError {
+	return &apiError{Status: status, Message: fmt.Sprintf(format, a...)}
+}
+
+// generateRequest describes the message to generate. The files of the
+// MetadataCreate messages are listed from the bucket, the default body is
+// used when the bucket is empty.
+type generateRequest struct {
+	Type      string   `json:"type"` // MetadataCreate by default.
+	Bucket    string   `json:"bucket"`
+	Prefix    string   `json:"prefix"`
+	Token     string   `json:"token"`     // Continuation token of the listing.
+	All       bool     `json:"all"`       // All the objects under the prefix, up to -s3-max-objects.
+	Keys      []string `json:"keys"`      // Only these objects, all of them are listed.
+	Checksums bool     `json:"checksums"` // See -checksum-algorithms.
+}
+
+// withFiles reports whether the files of the message are listed from S3.
+func (req generateRequest) withFiles() bool {
+	return req.Type == message.MessageTypeMetadataCreate.String() && req.Bucket != ""
+}
+
+// listObjects lists the objects that become the files of the message and the
+// token of the next page.
+func (req generateRequest) listObjects() ([]*s3.Object, string, error) {
+	log.Printf("Accessing to S3, bucket=%s prefix=%s keys=%v all=%t", req.Bucket, req.Prefix, *s3MaxKeys, req.All || len(req.Keys) > 0)
+	if len(req.Keys) == 0 {
+		return listObjects(req.Bucket, req.Prefix, req.Token, req.All)
+	}
+	objects, _, err := listObjects(req.Bucket, req.Prefix, "", true)
+	if err != nil {
+		return nil, "", err
+	}
+	return pickObjects(objects, req.Keys), "", nil
+}
+
+// pickObjects returns the objects whose keys are given, in listing order.
+func pickObjects(objects []*s3.Object, keys []string) []*s3.Object {
+	selected := make(map[string]bool)
+	for _, key := range keys {
+		selected[key] = true
+	}
+	picked := []*s3.Object{}
+	for _, object := range objects {
+		if selected[*object.Key] {
+			picked = append(picked, object)
+		}
+	}
+	return picked
+}
+
+// composeRequested returns the message requested populated with the objects
+// given. progress, if not nil, is called every time that an object is
+// processed.
+func composeRequested(req generateRequest, objects []*s3.Object, progress func()) (*message.Message, error) {
+	m, err := composeMessage(req.Type)
+	if err != nil {
+		return nil, &apiError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	if req.withFiles() {
+		mcr, err := m.MetadataCreateRequest()
+		if err != nil {
+			return nil, err
+		}
+		mcr.ObjectFile = buildFiles(activeConnection().s3, req.Bucket, objects, req.Checksums, progress)
+	}
+	return m, nil
+}
+
+// generateResponse is a message generated by the API.
+type generateResponse struct {
+	MessageType string          `json:"messageType"`
+	MessageID   string          `json:"messageId"`
+	Files       int             `json:"files"`
+	NextToken   string          `json:"nextToken,omitempty"`
+	Message     json.RawMessage `json:"message"`
+}
+
+// generateMessage returns the message described by the request.
+func generateMessage(req generateRequest) (*generateResponse, error) {
+	if req.Type == "" {
+		req.Type = message.MessageTypeMetadataCreate.String()
+	}
+	var (
+		objects   []*s3.Object
+		nextToken string
+	)
+	if req.withFiles() {
+		var err error
+		if objects, nextToken, err = req.listObjects(); err != nil {
+			log.Printf("[ERROR] S3 not available! bucket=%s prefix=%s - %s", req.Bucket, req.Prefix, err)
+			return nil, newAPIError(http.StatusBadGateway, "the objects of s3://%s/%s could not be listed: %s", req.Bucket, req.Prefix, err)
+		}
+	}
+	m, err := composeRequested(req, objects, nil)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := encodeMessage(m)
+	if err != nil {
+		return nil, err
+	}
+	return &generateResponse{
+		MessageType: req.Type,
+		MessageID:   m.MessageHeader.ID.String(),
+		Files:       len(objects),
+		NextToken:   nextToken,
+		Message:     blob,
+	}, nil
+}
+
+// validateResponse is the outcome of the validation of a message.
+type validateResponse struct {
+	Valid       bool              `json:"valid"`
+	Mode        string            `json:"mode"` // See -validation.
+	MessageType string            `json:"messageType"`
+	MessageID   string            `json:"messageId"`
+	Issues      []validationIssue `json:"issues"`
+}
+
+// checkMessage validates a message against the RDSS schemas.
+func checkMessage(blob []byte) *validateResponse {
+	resp := &validateResponse{
+		Mode:        validation.String(),
+		MessageType: messageType(blob),
+		Issues:      validateMessage(blob),
+	}
+	resp.MessageID, _ = recordIdentifiers(blob)
+	resp.Valid = len(resp.Issues) == 0
+	return resp
+}
+
+// sendResponse describes a message published, or previewed in a dry run.
+// Messages split because they don't fit in a Kinesis record are described
+// by their parts.
+type sendResponse struct {
+	MessageID       string            `json:"messageId"`
+	MessageType     string            `json:"messageType"`
+	ShardID         string            `json:"shardId,omitempty"`
+	SequenceNumber  string            `json:"sequenceNumber,omitempty"`
+	PartitionKey    string            `json:"partitionKey,omitempty"`
+	ExplicitHashKey string            `json:"explicitHashKey,omitempty"`
+	Stream          string            `json:"stream,omitempty"`
+	Detail          string            `json:"detail,omitempty"`
+	DryRun          bool              `json:"dryRun"`
+	Size            int               `json:"size"`
+	Record          string            `json:"record,omitempty"` // Dry run only.
+	Issues          []validationIssue `json:"issues,omitempty"` // Warnings, see -validation.
+	Position        int               `json:"position,omitempty"`
+	Total           int               `json:"total,omitempty"` // Parts of the split message.
+	Files           int               `json:"files,omitempty"`
+	Parts           []*sendResponse   `json:"parts,omitempty"`
+	Error           string            `json:"error,omitempty"`
+}
+
+// describeSent fills the response with the identifiers of the message and the
+// receipt of the publisher.
+func describeSent(resp *sendResponse, blob []byte, rcpt *receipt) {
+	resp.MessageID, _ = recordIdentifiers(blob)
+	resp.MessageType = messageType(blob)
+	resp.Size = len(blob)
+	if rcpt == nil {
+		return
+	}
+	resp.ShardID = rcpt.ShardID
+	resp.SequenceNumber = rcpt.SequenceNumber
+	resp.PartitionKey = rcpt.PartitionKey
+	resp.ExplicitHashKey = rcpt.ExplicitHashKey
+	resp.Stream = rcpt.Stream
+	resp.Detail = rcpt.Detail
+	resp.DryRun = rcpt.DryRun
+	if rcpt.DryRun {
+		resp.Size = rcpt.Size
+		resp.Record = string(rcpt.Data)
+	}
+}
+
+// publishMessage validates and sends a message. The messages that don't fit
+// in a Kinesis record are split when split is true.
+func publishMessage(ctx context.Context, blob []byte, dryRun, split bool) (*sendResponse, error) {
+	if len(bytes.TrimSpace(blob)) == 0 {
+		return nil, newAPIError(http.StatusBadRequest, "the message is empty")
+	}
+	if !json.Valid(blob) {
+		return nil, newAPIError(http.StatusBadRequest, "the message is not a valid JSON document")
+	}
+	// The indentation would count against the size of the Kinesis record.
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, blob); err != nil {
+		return nil, newAPIError(http.StatusBadRequest, "the message could not be compacted: %s", err)
+	}
+	blob = buf.Bytes()
+	resp := &sendResponse{}
+	if validation != validationModeDisabled {
+		resp.Issues = validateMessage(blob)
+		if len(resp.Issues) > 0 && validation == validationModeStrict {
+			return nil, &apiError{
+				Status:  http.StatusUnprocessableEntity,
+				Message: fmt.Sprintf("the message was not sent because the validator found %d issue(s)", len(resp.Issues)),
+				Issues:  resp.Issues,
+			}
+		}
+	}
+
+	if split && len(blob) > maxMessageSize {
+		return resp, publishParts(ctx, resp, blob, dryRun)
+	}
+
+	sctx, cancel := context.WithTimeout(withDryRun(ctx, dryRun), time.Second*4)
+	defer cancel()
+	rcpt, err := sendMessage(sctx, string(blob))
+	if err != nil {
+		if _, ok := err.(*recordSizeError); ok {
+			return nil, newAPIError(http.StatusRequestEntityTooLarge, "%s", err)
+		}
+		return nil, newAPIError(http.StatusBadGateway, "%s", err)
+	}
+	describeSent(resp, blob, rcpt)
+	return resp, nil
+}
+
+// publishParts splits a message too large for a Kinesis record and sends the
+// parts one by one. It stops at the first part that fails, the consumers
+// can't reassemble the sequence anyway, and only the parts attempted are
+// listed.
+func publishParts(ctx context.Context, resp *sendResponse, blob []byte, dryRun bool) error {
+	blobs, err := splitMessage(blob, maxMessageSize)
+	if err != nil {
+		return newAPIError(http.StatusRequestEntityTooLarge, "the message could not be split: %s", err)
+	}
+	resp.MessageID, _ = recordIdentifiers(blob)
+	resp.MessageType = messageType(blob)
+	resp.DryRun = dryRun
+	resp.Total = len(blobs)
+	for i, part := range blobs {
+		sctx, cancel := context.WithTimeout(withDryRun(ctx, dryRun), time.Second*4)
+		rcpt, err := sendMessage(sctx, string(part))
+		cancel()
+		presp := &sendResponse{Position: i + 1, Total: len(blobs)}
+		describeSent(presp, part, rcpt)
+		presp.DryRun = dryRun
+		if err != nil {
+			presp.Error = err.Error()
+		}
+		files := struct {
+			MessageBody struct {
+				ObjectFile []json.RawMessage `json:"objectFile"`
+			} `json:"messageBody"`
+		}{}
+		if err := json.Unmarshal(part, &files); err == nil {
+			presp.Files = len(files.MessageBody.ObjectFile)
+		}
+		resp.Size += presp.Size
+		resp.Parts = append(resp.Parts, presp)
+		if err != nil {
+			resp.Error = fmt.Sprintf("part %d of %d could not be sent, %d part(s) went out before it and the rest were not sent", i+1, len(blobs), i)
+			break
+		}
+	}
+	return nil
+}
+
+// writeJSON encodes the value as the body of the response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("[ERROR] API response could not be encoded: %s", err)
+	}
+}
+
+// writeAPIError reports the error, with its status code if it's an apiError.
+func writeAPIError(w http.ResponseWriter, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		e = &apiError{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	writeJSON(w, e.Status, e)
+}
+
+// maxAPIBodySize bounds the messages posted to the API, large enough for the
+// messages that need to be split.
+const maxAPIBodySize = 16 << 20
+
+// readAPIBody reads the message posted.
+func readAPIBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	blob, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	if err != nil {
+		return nil, newAPIError(http.StatusRequestEntityTooLarge, "the body could not be read: %s", err)
+	}
+	if len(bytes.TrimSpace(blob)) == 0 {
+		return nil, newAPIError(http.StatusBadRequest, "the body is empty, post the message as a JSON document")
+	}
+	return blob, nil
+}
+
+// allowMethod reports whether the method of the request is the one given,
+// otherwise it responds with 405.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeAPIError(w, newAPIError(http.StatusMethodNotAllowed, "use %s", method))
+	return false
+}
+
+// queryBool parses a boolean parameter of the query, false when missing.
+func queryBool(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, newAPIError(http.StatusBadRequest, "invalid %s %q, use true or false", name, value)
+	}
+	return b, nil
+}
+
+// apiGenerateHandler generates a message, e.g.:
+//
+//	GET /api/generate?type=MetadataCreate&bucket=mybucket&prefix=dataset/&all=true
+func apiGenerateHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	query := r.URL.Query()
+	req := generateRequest{
+		Type:      query.Get("type"),
+		Bucket:    query.Get("bucket"),
+		Prefix:    query.Get("prefix"),
+		Token:     query.Get("token"),
+		Keys:      query["key"],
+		Checksums: *checksums,
+	}
+	var err error
+	if req.All, err = queryBool(r, "all"); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if query.Get("checksums") != "" {
+		if req.Checksums, err = queryBool(r, "checksums"); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+	}
+	resp, err := generateMessage(req)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// apiValidateHandler validates the message posted.
+func apiValidateHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	blob, err := readAPIBody(w, r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, checkMessage(blob))
+}
+
+// apiSendHandler sends the message posted. The query parameters dryRun and
+// split override -dry-run and -split-messages.
+func apiSendHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	blob, err := readAPIBody(w, r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	dryRun, split := *dryRun, *splitMessages
+	if r.URL.Query().Get("dryRun") != "" {
+		if dryRun, err = queryBool(r, "dryRun"); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+	}
+	if r.URL.Query().Get("split") != "" {
+		if split, err = queryBool(r, "split"); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+	}
+	resp, err := publishMessage(r.Context(), blob, dryRun, split)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if resp.Error != "" {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// batchRecord is the outcome of publishing one of the messages of a batch.
+type batchRecord struct {
+	Index       int
+	MessageID   string
+	MessageType string
+	Receipt     *receipt
+	ErrorCode   string
+	Error       string
+	Attempts    int
+	Issues      []validationIssue
+}
+
+// BatchPublisher is implemented by the publishers that can send several
+// messages in a single request, e.g. Kinesis' PutRecords. Publishers that
+// don't implement it send the messages of a batch one by one.
+type BatchPublisher interface {
+	PublishBatch(ctx context.Context, records []*batchRecord, data [][]byte)
+}
+
+// parseBatch reads the messages of a batch given as a JSON array or as JSONL,
+// i.e. one message per line. The messages are compacted, the indentation
+// would count against the size of the Kinesis records.
+func parseBatch(input string) ([][]byte, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return nil, fmt.Errorf("the batch is empty")
+	}
+	if strings.HasPrefix(input, "[") {
+		var items []json.RawMessage
+		if err := json.Unmarshal([]byte(input), &items); err != nil {
+			return nil, fmt.Errorf("the JSON array could not be decoded: %s", err)
+		}
+		blobs := make([][]byte, len(items))
+		for i, item := range items {
+			buf := &bytes.Buffer{}
+			if err := json.Compact(buf, item); err != nil {
+				return nil, fmt.Errorf("message %d could not be compacted: %s", i+1, err)
+			}
+			blobs[i] = buf.Bytes()
+		}
+		return blobs, nil
+	}
+	// The lines are not limited in size, the records too large for Kinesis
+	// are reported one by one like in the JSON arrays.
+	blobs := [][]byte{}
+	for n, line := range strings.Split(input, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if err := json.Compact(buf, []byte(line)); err != nil {
+			return nil, fmt.Errorf("line %d is not a valid JSON document", n+1)
+		}
+		blobs = append(blobs, buf.Bytes())
+	}
+	return blobs, nil
+}
+
+// sendBatch validates and publishes the messages of a batch. In strict mode
+// the invalid messages are reported and not sent.
+func sendBatch(ctx context.Context, blobs [][]byte) []*batchRecord {
+	records := make([]*batchRecord, len(blobs))
+	pending := []*batchRecord{}
+	data := [][]byte{}
+	for i, blob := range blobs {
+		record := &batchRecord{Index: i + 1, MessageType: messageType(blob)}
+		record.MessageID, _ = recordIdentifiers(blob)
+		records[i] = record
+		if validation != validationModeDisabled {
+			record.Issues = validateMessage(blob)
+			if len(record.Issues) > 0 && validation == validationModeStrict {
+				record.ErrorCode = "ValidationFailed"
+				record.Error = fmt.Sprintf("The validator found %d issue(s)", len(record.Issues))
+				continue
+			}
+		}
+		pending = append(pending, record)
+		data = append(data, blob)
+	}
+
+	if isDryRun(ctx) {
+		for i, record := range pending {
+			rcpt, err := previewMessage(ctx, data[i])
+			if err != nil {
+				record.ErrorCode = "PreviewFailed"
+				record.Error = err.Error()
+				continue
+			}
+			record.Receipt = rcpt
+		}
+		return records
+	}
+	p := activeConnection().publisher
+	if bp, ok := p.(BatchPublisher); ok {
+		bp.PublishBatch(ctx, pending, data)
+	} else {
+		publishEach(ctx, p, pending, data)
+	}
+	for i, record := range pending {
+		if record.Receipt != nil {
+			recordSent(ctx, data[i], record.Receipt)
+		}
+	}
+	return records
+}
+
+// publishEach sends the messages of a batch one by one.
+func publishEach(ctx context.Context, p Publisher, records []*batchRecord, data [][]byte) {
+	for i, record := range records {
+		record.Attempts = 1
+		rcpt, err := p.Publish(ctx, data[i])
+		if err != nil {
+			record.ErrorCode = "PublishFailed"
+			record.Error = err.Error()
+			continue
+		}
+		record.Receipt = rcpt
+	}
+}
+
+const batchHTML = `{{template "header" .}}
+		{{if .Records}}
+			<h3>Batch {{if .DryRun}}previewed{{else}}sent{{end}} ({{.Publisher}}).</h3>
+			<div class="{{if .Failed}}error{{else}}result{{end}}">{{if .DryRun}}Dry run, nothing was sent: {{.Sent}} of {{len .Records}} message(s) would have been sent, {{.Failed}} failed.{{else}}{{.Sent}} of {{len .Records}} message(s) sent, {{.Failed}} failed.{{end}}</div>
+			<table>
+				<thead>
+					<tr><th>#</th><th>Message</th><th>{{if .DryRun}}PartitionKey{{else}}ShardId{{end}}</th><th>{{if .DryRun}}Size{{else}}SequenceNumber{{end}}</th><th>Attempts</th><th>Error</th></tr>
+				</thead>
+				<tbody>
+					{{range .Records}}
+						<tr>
+							<td>{{.Index}}</td>
+							<td><code>{{.MessageType}}</code><br /><small>{{if and .Receipt (not .Receipt.DryRun)}}<a href="{{url "/sent/"}}{{.MessageID}}">{{.MessageID}}</a>{{else}}{{.MessageID}}{{end}}</small></td>
+							<td>{{with .Receipt}}{{if .DryRun}}{{.PartitionKey}}{{else}}{{.ShardID}}{{end}}{{end}}</td>
+							<td>{{with .Receipt}}{{if .DryRun}}{{.Size}} bytes{{else}}{{.SequenceNumber}}{{end}}{{end}}</td>
+							<td>{{.Attempts}}</td>
+							<td>
+								{{if .ErrorCode}}<code>{{.ErrorCode}}</code> {{.Error}}{{end}}
+								{{if .Issues}}<ul>{{range .Issues}}<li><code>{{.Field}}</code>: {{.Description}}</li>{{end}}</ul>{{end}}
+							</td>
+						</tr>
+					{{end}}
+				</tbody>
+			</table>
+			<hr />
+			<a href="{{url "/batch"}}">Send a new batch</a>
+		{{else}}
+			<h3>Send a batch of messages ({{.Publisher}}).</h3>
+			{{if .Error}}<div class="error"><p>{{.Error}}</p></div>{{end}}
+			<p>Paste the messages as a JSON array or one message per line (JSONL). Kinesis receives them with <code>PutRecords</code> in chunks of up to {{.MaxRecords}} records and the records that fail are retried up to {{.MaxRetries}} time(s).</p>
+			<form method="POST">
+				<textarea name="messages">{{.Input}}</textarea>
+				<p><input type="checkbox" name="dry-run" id="dry-run" value="true"{{if .DryRun}} checked{{end}} /><label class="label-inline" for="dry-run">Dry run, show what would be published without sending it</label></p>
+				<button type="submit" class="button">Send</button>
+			</form>
+		{{end}}
+{{template "footer" .}}`
+
+var batchTmpl = template.Must(template.Must(template.New("batch").Funcs(layoutFuncs).Parse(batchHTML)).Parse(layout))
+
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	data := struct {
+		Publisher  string
+		MaxRecords int
+		MaxRetries int
+		Input      string
+		Error      string
+		Records    []*batchRecord
+		Sent       int
+		Failed     int
+		DryRun     bool
+	}{
+		Publisher:  *publisherName,
+		DryRun:     *dryRun,
+		MaxRecords: kinesisMaxBatchRecords,
+		MaxRetries: *kinesisMaxRetries,
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		data.Input = r.PostFormValue("messages")
+		blobs, err := parseBatch(data.Input)
+		if err != nil {
+			data.Error = fmt.Sprintf("The messages could not be read: %s", err)
+			break
+		}
+		data.DryRun = r.PostFormValue("dry-run") != ""
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		ctx = withDryRun(ctx, data.DryRun)
+		data.Records = sendBatch(ctx, blobs)
+		for _, record := range data.Records {
+			if record.Receipt != nil {
+				data.Sent++
+			} else {
+				data.Failed++
+			}
+		}
+		log.Printf("Batch sent: records=%d sent=%d failed=%d", len(data.Records), data.Sent, data.Failed)
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := executeTemplate(w, r, batchTmpl, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseBatch(t *testing.T) {
+	padding := strings.Repeat("x", kinesisMaxRecordSize)
+	large := fmt.Sprintf(`{"padding": "%s"}`, padding)
+	compactLarge := fmt.Sprintf(`{"padding":"%s"}`, padding)
+	tests := []struct {
+		name     string
+		input    string
+		messages []string // Compacted, nil if it fails.
+	}{
+		{
+			name:  "empty",
+			input: " \n ",
+		},
+		{
+			name:     "array",
+			input:    `[{"a": 1}, {"b": 2}, {"c": 3}]`,
+			messages: []string{`{"a":1}`, `{"b":2}`, `{"c":3}`},
+		},
+		{
+			name:     "array of indented messages",
+			input:    "[\n  {\n    \"a\": 1\n  },\n  {\"b\": 2}\n]",
+			messages: []string{`{"a":1}`, `{"b":2}`},
+		},
+		{
+			name:  "invalid array",
+			input: `[{"a": 1},]`,
+		},
+		{
+			name:     "jsonl",
+			input:    "{\"a\": 1}\n\n  {\"b\": 2}  \r\n{\"c\": 3}\n",
+			messages: []string{`{"a":1}`, `{"b":2}`, `{"c":3}`},
+		},
+		{
+			name:  "jsonl with an invalid line",
+			input: "{\"a\": 1}\n{\"b\":\n",
+		},
+		{
+			name:  "jsonl with an indented message",
+			input: "{\n  \"a\": 1\n}",
+		},
+		{
+			name:     "jsonl line over 1 MiB",
+			input:    "{\"a\": 1}\n" + large + "\n{\"c\": 3}",
+			messages: []string{`{"a":1}`, compactLarge, `{"c":3}`},
+		},
+		{
+			name:     "array with a message over 1 MiB",
+			input:    "[" + large + "]",
+			messages: []string{compactLarge},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			blobs, err := parseBatch(tc.input)
+			if tc.messages == nil {
+				if err == nil {
+					t.Fatalf("got %d messages, want an error", len(blobs))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(blobs) != len(tc.messages) {
+				t.Fatalf("got %d messages, want %d", len(blobs), len(tc.messages))
+			}
+			for i, blob := range blobs {
+				if string(blob) != tc.messages[i] {
+					t.Errorf("message %d is %.40q (%d bytes), want %.40q (%d bytes)", i+1, blob, len(blob), tc.messages[i], len(tc.messages[i]))
+				}
+			}
+		})
+	}
+}
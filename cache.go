@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
+)
+
+// cacheEntry holds the checksums of an object. The entry is only valid for
+// the version of the object given by its ETag.
+type cacheEntry struct {
+	Bucket    string            `json:"bucket"`
+	Key       string            `json:"key"`
+	ETag      string            `json:"etag"`
+	Checksums map[string]string `json:"checksums"`
+	Updated   time.Time         `json:"updated"`
+}
+
+// checksumCache is a persistent store of checksums. Entries are keyed by
+// bucket and key and they are invalidated when the ETag of the object changes.
+// The whole cache is written to disk every time that it's updated, it is not
+// expected to grow beyond a few thousand entries.
+type checksumCache struct {
+	path    string
+	entries map[string]*cacheEntry
+	mu      sync.RWMutex
+}
+
+// newChecksumCache loads the cache persisted in path. An empty path gives an
+// in-memory cache.
+func newChecksumCache(path string) (*checksumCache, error) {
+	c := &checksumCache{
+		path:    path,
+		entries: make(map[string]*cacheEntry),
+	}
+	if path == "" {
+		return c, nil
+	}
+	blob, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(blob, &c.entries); err != nil {
+		return nil, fmt.Errorf("checksum cache %s is corrupted: %s", path, err)
+	}
+	return c, nil
+}
+
+func cacheKey(bucket, key string) string {
+	return fmt.Sprintf("%s:%s", bucket, key)
+}
+
+// normalizeETag removes the quotes that S3 adds to the ETag values.
+func normalizeETag(etag string) string {
+	return strings.Trim(etag, `"`)
+}
+
+// Get looks up a checksum. Entries of a different version of the object are
+// ignored.
+func (c *checksumCache) Get(bucket, key, etag string, t message.ChecksumTypeEnum) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[cacheKey(bucket, key)]
+	if !ok || entry.ETag != normalizeETag(etag) {
+		return "", false
+	}
+	sum, ok := entry.Checksums[t.String()]
+	return sum, ok
+}
+
+// Put stores checksums. Previous entries of the object are discarded if the
+// ETag has changed.
+func (c *checksumCache) Put(bucket, key, etag string, sums map[message.ChecksumTypeEnum]string) error {
+	etag = normalizeETag(etag)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[cacheKey(bucket, key)]
+	if !ok || entry.ETag != etag {
+		if ok {
+			log.Printf("Checksum cache entry invalidated (bucket=%s key=%s old=%s new=%s)", bucket, key, entry.ETag, etag)
+		}
+		entry = &cacheEntry{
+			Bucket:    bucket,
+			Key:       key,
+			ETag:      etag,
+			Checksums: make(map[string]string),
+		}
+		c.entries[cacheKey(bucket, key)] = entry
+	}
+	for t, sum := range sums {
+		entry.Checksums[t.String()] = sum
+	}
+	entry.Updated = time.Now()
+	return c.save()
+}
+
+// Entries returns a copy of all the entries sorted by bucket and key. The
+// checksums are copied too, Put keeps updating the maps of the cache.
+func (c *checksumCache) Entries() []cacheEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entries := make([]cacheEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		item := *entry
+		item.Checksums = make(map[string]string, len(entry.Checksums))
+		for t, sum := range entry.Checksums {
+			item.Checksums[t] = sum
+		}
+		entries = append(entries, item)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Bucket != entries[j].Bucket {
+			return entries[i].Bucket < entries[j].Bucket
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// Evict removes the entry of an object.
+func (c *checksumCache) Evict(bucket, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, cacheKey(bucket, key))
+	return c.save()
+}
+
+// Purge removes all the entries.
+func (c *checksumCache) Purge() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*cacheEntry)
+	return c.save()
+}
+
+// save writes the cache to disk. The caller must hold the lock.
+func (c *checksumCache) save() error {
+	if c.path == "" {
+		return nil
+	}
+	blob, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so the cache is never left truncated.
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, blob, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+const checksumsHTML = `{{template "header" .}}
+		<h3>Checksum cache</h3>
+		{{if .Path}}
+			<p>Checksums are persisted in <code>{{.Path}}</code>. An entry is discarded when the ETag of the object changes.</p>
+		{{else}}
+			<p>Checksums are kept in memory, use <code>-checksum-cache</code> to persist them.</p>
+		{{end}}
+		{{if .Entries}}
+			<table>
+				<thead>
+					<tr><th>Object</th><th>ETag</th><th>Checksums</th><th>Updated</th><th></th></tr>
+				</thead>
+				<tbody>
+					{{range .Entries}}
+						<tr>
+							<td><code>s3://{{.Bucket}}/{{.Key}}</code></td>
+							<td><code>{{.ETag}}</code></td>
+							<td>{{range $type, $sum := .Checksums}}{{$type}}: <code>{{$sum}}</code><br />{{end}}</td>
+							<td>{{.Updated.Format "2006-01-02 15:04:05"}}</td>
+							<td>
+								<form method="POST">
+									<input type="hidden" name="bucket" value="{{.Bucket}}" />
+									<input type="hidden" name="key" value="{{.Key}}" />
+									<button type="submit" class="button button-outline">Evict</button>
+								</form>
+							</td>
+						</tr>
+					{{end}}
+				</tbody>
+			</table>
+			<form method="POST">
+				<input type="hidden" name="all" value="true" />
+				<button type="submit" class="button">Evict all</button>
+			</form>
+		{{else}}
+			<p>The cache is empty.</p>
+		{{end}}
+{{template "footer" .}}`
+
+var checksumsTmpl = template.Must(template.Must(template.New("checksums").Funcs(layoutFuncs).Parse(checksumsHTML)).Parse(layout))
+
+func checksumsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	if r.Method == http.MethodPost {
+		var err error
+		if r.PostFormValue("all") != "" {
+			err = checksumStore.Purge()
+		} else {
+			err = checksumStore.Evict(r.PostFormValue("bucket"), r.PostFormValue("key"))
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("The cache could not be updated: %s", err), http.StatusInternalServerError)
+			return
+		}
+		redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := executeTemplate(w, r, checksumsTmpl, struct {
+		Path    string
+		Entries []cacheEntry
+	}{checksumStore.path, checksumStore.Entries()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -3,93 +3,160 @@ package main
 import (
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
-	"io/ioutil"
 	"log"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
 )
 
+// checksum is the digest of an object computed with a given algorithm.
+type checksum struct {
+	Type  message.ChecksumTypeEnum
+	Value string
+}
+
+// checksumAlgorithms are the algorithms supported, indexed by the checksum
+// type used in the RDSS messages.
+var checksumAlgorithms = map[message.ChecksumTypeEnum]func() hash.Hash{
+	message.ChecksumTypeEnum_md5:    md5.New,
+	message.ChecksumTypeEnum_sha256: sha256.New,
+}
+
+// parseChecksumAlgorithms parses a comma-separated list of algorithms, e.g.
+// "md5,sha256".
+func parseChecksumAlgorithms(value string) ([]message.ChecksumTypeEnum, error) {
+	algorithms := []message.ChecksumTypeEnum{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var found bool
+		for t := range checksumAlgorithms {
+			if t.String() == name {
+				algorithms = append(algorithms, t)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown checksum algorithm %q", name)
+		}
+	}
+	return algorithms, nil
+}
+
 type Hasher interface {
-	Sum(bucket, key string) string
+	// Sum returns the checksums of the object, one for each algorithm given.
+	// The ETag identifies the version of the object, which is read with the
+	// client of the target that it belongs to.
+	Sum(client *s3.S3, bucket, key, etag string, algorithms []message.ChecksumTypeEnum) []checksum
 }
 
-// Dirty global for this quick hack.
-var md5hasher Hasher
+// Dirty globals for this quick hack.
+var (
+	defaultHasher Hasher
+	checksumStore *checksumCache
+)
 
-type md5sum struct {
-	s3Client *s3.S3
-	items    map[string]string
-	mu       sync.RWMutex
+type streamHasher struct {
+	cache       *checksumCache
+	idleTimeout time.Duration
 }
 
-// How long are we willing to wait for a S3 file to be donwloaded.
+// How long are we willing to wait for the metadata requests sent to S3 and
+// Kinesis. Downloads are bounded by the idle timeout of the hasher instead.
 const timeout = 5 * time.Second
 
-func hasher(s3Client *s3.S3, bucket, key, etag string) string {
-	if md5hasher == nil {
-		md5hasher = &md5sum{
-			s3Client: s3Client,
-			items:    make(map[string]string),
-		}
-	}
-	return md5hasher.Sum(bucket, key)
+// idleReader cancels the download when no data is received for a while. The
+// timer starts before the request is sent so it covers the response headers.
+type idleReader struct {
+	r     io.Reader
+	timer *time.Timer
+	idle  time.Duration
 }
 
-// Looks up the sum in the cache.
-func (c *md5sum) has(lookupKey string) (string, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	sum, ok := c.items[lookupKey]
-	return sum, ok
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.timer.Reset(r.idle)
+	return n, err
 }
 
-// calc downloads the file from S3 and calculates its checksum.
-func (c *md5sum) calc(bucket, key string) string {
-	tmpfile, err := ioutil.TempFile("", key)
-	if err != nil {
-		log.Printf("[ERROR] ioutil.TempFile failed: %s", err)
-		return ""
-	}
-	defer tmpfile.Close()
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+// hasher returns the checksums of an object. defaultHasher must be set before
+// the workers are started.
+func hasher(client *s3.S3, bucket, key, etag string) []checksum {
+	return defaultHasher.Sum(client, bucket, key, etag, checksumTypes)
+}
+
+// calc streams the object from S3 and calculates its checksums. The object is
+// read only once regardless of the number of algorithms.
+func (c *streamHasher) calc(client *s3.S3, bucket, key string, algorithms []message.ChecksumTypeEnum) map[message.ChecksumTypeEnum]string {
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	downloader := s3manager.NewDownloaderWithClient(c.s3Client)
-	_, err = downloader.DownloadWithContext(ctx, tmpfile, &s3.GetObjectInput{
+	timer := time.AfterFunc(c.idleTimeout, cancel)
+	defer timer.Stop()
+	resp, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
 	if err != nil {
-		log.Printf("[ERROR] S3 downloader failed: %s", err)
-		return ""
+		log.Printf("[ERROR] S3 GetObject failed: %s", err)
+		return nil
+	}
+	defer resp.Body.Close()
+	hashes := make(map[message.ChecksumTypeEnum]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, 0, len(algorithms))
+	for _, t := range algorithms {
+		h := checksumAlgorithms[t]()
+		hashes[t] = h
+		writers = append(writers, h)
 	}
-	md5hasher := md5.New()
-	if _, err := io.Copy(md5hasher, tmpfile); err != nil {
-		log.Printf("[ERROR] md5 calculation failed: %s", err)
-		return ""
+	body := &idleReader{r: resp.Body, timer: timer, idle: c.idleTimeout}
+	if _, err := io.Copy(io.MultiWriter(writers...), body); err != nil {
+		log.Printf("[ERROR] checksum calculation failed: %s", err)
+		return nil
 	}
-	return hex.EncodeToString(md5hasher.Sum(nil)[:16])
+	sums := make(map[message.ChecksumTypeEnum]string, len(hashes))
+	for t, h := range hashes {
+		sums[t] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums
 }
 
-func (c *md5sum) Sum(bucket, key string) string {
-	var lookupKey = fmt.Sprintf("%s:%s", bucket, key)
-	sum, ok := c.has(lookupKey)
-	if ok {
-		log.Printf("MD5 checksum found in the cache (bucket=%s key=%s sum=%s)", bucket, key, sum)
-		return sum
+func (c *streamHasher) Sum(client *s3.S3, bucket, key, etag string, algorithms []message.ChecksumTypeEnum) []checksum {
+	missing := []message.ChecksumTypeEnum{}
+	for _, t := range algorithms {
+		if sum, ok := c.cache.Get(bucket, key, etag, t); ok {
+			log.Printf("Checksum found in the cache (bucket=%s key=%s type=%s sum=%s)", bucket, key, t, sum)
+		} else {
+			missing = append(missing, t)
+		}
+	}
+	if len(missing) > 0 {
+		sums := c.calc(client, bucket, key, missing)
+		for t, sum := range sums {
+			log.Printf("Checksum generated (bucket=%s key=%s type=%s sum=%s)", bucket, key, t, sum)
+		}
+		if len(sums) > 0 {
+			if err := c.cache.Put(bucket, key, etag, sums); err != nil {
+				log.Printf("[ERROR] Checksum cache could not be saved: %s", err)
+			}
+		}
 	}
-	sum = c.calc(bucket, key)
-	log.Printf("MD5 checksum generated (bucket=%s key=%s sum=%s)", bucket, key, sum)
-	if sum != "" {
-		c.mu.RLock()
-		defer c.mu.RUnlock()
-		c.items[lookupKey] = sum
+	checksums := []checksum{}
+	for _, t := range algorithms {
+		if sum, ok := c.cache.Get(bucket, key, etag, t); ok {
+			checksums = append(checksums, checksum{Type: t, Value: sum})
+		}
 	}
-	return sum
+	return checksums
 }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
+)
+
+// command is a subcommand of msgcreator. run receives the arguments that
+// follow the flags, e.g. the files given to validate.
+type command struct {
+	usage       string
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve": {
+		usage:       "serve",
+		description: "Run the HTTP server (default)",
+		run:         runServe,
+	},
+	"generate": {
+		usage:       "generate [bucket[/prefix]]",
+		description: "Print a message to stdout, MetadataCreate messages are populated with the files found in the bucket (see -generate-type)",
+		run:         runGenerate,
+	},
+	"validate": {
+		usage:       "validate [file...]",
+		description: "Validate the messages of the files or stdin against the RDSS schemas",
+		run:         runValidate,
+	},
+	"send": {
+		usage:       "send [file...]",
+		description: "Validate and publish the messages of the files or stdin, the results are printed to stdout as JSONL so the stdout publisher can't be used",
+		run:         runSend,
+	},
+	"replay": {
+		usage:       "replay [file]",
+		description: "Publish the messages captured in a JSONL file or stdin (see -replay-ids, -replay-speed and -replay-types)",
+		run:         runReplay,
+	},
+	"load-test": {
+		usage:       "load-test",
+		description: "Send MetadataCreate messages at a steady rate and report the results (see -load-test-rate...)",
+		run:         runLoadTest,
+	},
+}
+
+// usage prints the commands and the flags shared by all of them.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command] [flags] [args]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\n    \t%s\n", commands[name].usage, commands[name].description)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// parseCommand parses the flags and returns the command and its arguments.
+// Flags can be given before or after the command, e.g. "-publisher stdout
+// send msg.json" and "send -publisher stdout msg.json" are equivalent. The
+// command is serve when none is given.
+func parseCommand(args []string) (string, []string) {
+	name := "serve"
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			name, args = args[0], args[1:]
+		}
+	}
+	flag.CommandLine.Parse(args)
+	rest := flag.Args()
+	if name == "serve" && len(rest) > 0 {
+		if _, ok := commands[rest[0]]; !ok {
+			fmt.Fprintf(flag.CommandLine.Output(), "Unknown command %q\n", rest[0])
+			flag.Usage()
+			os.Exit(2)
+		}
+		name = rest[0]
+		flag.CommandLine.Parse(rest[1:])
+		rest = flag.Args()
+	}
+	return name, rest
+}
+
+// readMessages reads the messages of a file, "-" is the standard input. A
+// file holds a single message, a JSON array of messages or one message per
+// line (JSONL).
+func readMessages(path string) ([][]byte, error) {
+	f, err := openCapture(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	blob, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	blob = bytes.TrimSpace(blob)
+	if bytes.HasPrefix(blob, []byte("{")) && json.Valid(blob) {
+		return [][]byte{blob}, nil
+	}
+	return parseBatch(string(blob))
+}
+
+// eachMessage calls fn with the messages of the files given, or the standard
+// input when there are none. The name identifies the message in the output,
+// e.g. "msg.jsonl:3". It returns the number of messages read.
+func eachMessage(paths []string, fn func(name string, blob []byte)) (int, error) {
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	var n int
+	for _, path := range paths {
+		blobs, err := readMessages(path)
+		if err != nil {
+			return n, fmt.Errorf("%s: %s", path, err)
+		}
+		for i, blob := range blobs {
+			name := path
+			if len(blobs) > 1 {
+				name = fmt.Sprintf("%s:%d", path, i+1)
+			}
+			fn(name, blob)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func runGenerate(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("generate takes a single bucket, e.g. mybucket/some/prefix")
+	}
+	req := generateRequest{
+		Type:      *generateType,
+		All:       *generateAll,
+		Checksums: *checksums,
+	}
+	if req.Type == message.MessageTypeMetadataCreate.String() {
+		query := activeTarget().S3DefaultBucket
+		if len(args) > 0 {
+			query = args[0]
+		}
+		req.Bucket, req.Prefix = splitBucketPrefix(query)
+	}
+	resp, err := generateMessage(req)
+	if err != nil {
+		return err
+	}
+	if resp.NextToken != "" {
+		log.Printf("Only the first %d objects were included, use -generate-all to include all of them", resp.Files)
+	}
+	fmt.Printf("%s\n", resp.Message)
+	return nil
+}
+
+func runValidate(args []string) error {
+	if validation == validationModeDisabled {
+		return fmt.Errorf("validate can't be used with -validation=disabled")
+	}
+	var invalid int
+	n, err := eachMessage(args, func(name string, blob []byte) {
+		resp := checkMessage(blob)
+		if resp.Valid {
+			fmt.Printf("%s: valid %s message\n", name, resp.MessageType)
+			return
+		}
+		invalid++
+		fmt.Printf("%s: %d issue(s)\n", name, len(resp.Issues))
+		for _, issue := range resp.Issues {
+			fmt.Printf("  %s: %s\n", issue.Field, issue.Description)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d message(s) are not valid", invalid, n)
+	}
+	return nil
+}
+
+func runSend(args []string) error {
+	// The results would be mixed with the messages on the standard output.
+	if *publisherName == "stdout" && !*dryRun {
+		return fmt.Errorf("send prints its results to stdout, use -publisher=file to write the messages instead of the stdout publisher")
+	}
+	var failed int
+	enc := json.NewEncoder(os.Stdout)
+	n, err := eachMessage(args, func(name string, blob []byte) {
+		resp, err := publishMessage(context.Background(), blob, *dryRun, *splitMessages)
+		if err != nil {
+			failed++
+			log.Printf("[ERROR] %s: %s", name, err)
+			e, ok := err.(*apiError)
+			if !ok {
+				e = &apiError{Message: err.Error()}
+			}
+			enc.Encode(e)
+			return
+		}
+		if resp.Error != "" {
+			failed++
+			log.Printf("[ERROR] %s: %s", name, resp.Error)
+		}
+		enc.Encode(resp)
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d message(s) could not be sent", failed, n)
+	}
+	return nil
+}
+
+func runReplay(args []string) error {
+	path := *replayPath
+	if len(args) > 0 {
+		path = args[0]
+	}
+	if path == "" {
+		path = "-"
+	}
+	opts, err := parseReplayOptions(*replayIDs, *replaySpeed, *replayTypes)
+	if err != nil {
+		return err
+	}
+	return replayFile(path, opts)
+}
+
+func runLoadTest(args []string) error {
+	t := loadTest{
+		Rate:        *loadTestRate,
+		Duration:    *loadTestDuration,
+		Concurrency: *loadTestConcurrency,
+	}
+	if err := t.check(); err != nil {
+		return err
+	}
+	log.Printf("Load test started: rate=%v/s duration=%s concurrency=%d publisher=%s", t.Rate, t.Duration, t.Concurrency, *publisherName)
+	report := t.Run()
+	report.Print(os.Stderr)
+	if report.Failed > 0 {
+		return fmt.Errorf("%d message(s) could not be sent", report.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	draftKindDraft    = "draft"
+	draftKindTemplate = "template"
+)
+
+// draft is a message saved from the compose form. Drafts are the work in
+// progress of a user while templates are the scenarios shared by the team.
+type draft struct {
+	Name        string    `json:"name"`
+	Kind        string    `json:"kind"`
+	MessageType string    `json:"messageType"`
+	Body        string    `json:"body"`
+	Updated     time.Time `json:"updated"`
+
+	// Path is the file of the templates loaded from the template directory,
+	// they can't be changed from the UI.
+	Path string `json:"-"`
+}
+
+var draftNameRe = regexp.MustCompile("^[0-9A-Za-z._-]+$")
+
+func draftKey(kind, name string) string {
+	return fmt.Sprintf("%s:%s", kind, name)
+}
+
+// draftStore keeps the drafts and the templates. The whole store is written
+// to disk every time that it's updated, like the checksum cache.
+type draftStore struct {
+	path   string
+	drafts map[string]*draft
+	mu     sync.RWMutex
+}
+
+// newDraftStore loads the drafts persisted in path and the templates found in
+// dir, i.e. the messages saved as *.json files. Empty values give an
+// in-memory store and no templates.
+func newDraftStore(path, dir string) (*draftStore, error) {
+	s := &draftStore{
+		path:   path,
+		drafts: make(map[string]*draft),
+	}
+	if path != "" {
+		blob, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(blob, &s.drafts); err != nil {
+				return nil, fmt.Errorf("drafts %s are corrupted: %s", path, err)
+			}
+		}
+	}
+	if dir == "" {
+		return s, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		if !draftNameRe.MatchString(name) {
+			return nil, fmt.Errorf("template %s: the name can only have letters, digits, dots, dashes and underscores", path)
+		}
+		blob, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid(blob) {
+			return nil, fmt.Errorf("template %s is not a valid JSON document", path)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		s.drafts[draftKey(draftKindTemplate, name)] = &draft{
+			Name:        name,
+			Kind:        draftKindTemplate,
+			MessageType: messageType(blob),
+			Body:        string(blob),
+			Updated:     info.ModTime(),
+			Path:        path,
+		}
+	}
+	log.Printf("Templates loaded: dir=%s templates=%d", dir, len(paths))
+	return s, nil
+}
+
+// Get returns a copy of a draft or a template.
+func (s *draftStore) Get(kind, name string) (draft, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.drafts[draftKey(kind, name)]
+	if !ok {
+		return draft{}, false
+	}
+	return *d, true
+}
+
+// Save stores the message under the given name, replacing the previous
+// version. The templates of the template directory can't be replaced.
+func (s *draftStore) Save(kind, name, body string) error {
+	if kind != draftKindDraft && kind != draftKindTemplate {
+		return fmt.Errorf("unknown kind %q", kind)
+	}
+	if !draftNameRe.MatchString(name) {
+		return fmt.Errorf("the name can only have letters, digits, dots, dashes and underscores")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.drafts[draftKey(kind, name)]; ok && d.Path != "" {
+		return fmt.Errorf("%s is loaded from %s, choose a different name", name, d.Path)
+	}
+	s.drafts[draftKey(kind, name)] = &draft{
+		Name:        name,
+		Kind:        kind,
+		MessageType: messageType([]byte(body)),
+		Body:        body,
+		Updated:     time.Now(),
+	}
+	return s.save()
+}
+
+// Delete removes a draft or a template.
+func (s *draftStore) Delete(kind, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.drafts[draftKey(kind, name)]
+	if !ok {
+		return nil
+	}
+	if d.Path != "" {
+		return fmt.Errorf("%s is loaded from %s, remove the file instead", name, d.Path)
+	}
+	delete(s.drafts, draftKey(kind, name))
+	return s.save()
+}
+
+// List returns copies of the templates and the drafts, sorted by kind and
+// name. The bodies are left out.
+func (s *draftStore) List() []draft {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]draft, 0, len(s.drafts))
+	for _, d := range s.drafts {
+		item := *d
+		item.Body = ""
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Kind != list[j].Kind {
+			return list[i].Kind > list[j].Kind
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// save writes the store to disk, without the templates of the template
+// directory. The caller must hold the lock.
+func (s *draftStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	saved := make(map[string]*draft)
+	for key, d := range s.drafts {
+		if d.Path == "" {
+			saved[key] = d
+		}
+	}
+	blob, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so the drafts are never left truncated.
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, blob, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+var (
+	draftsRe = regexp.MustCompile("^/drafts/(draft|template)/([0-9A-Za-z._-]+)$")
+
+	drafts *draftStore
+)
+
+// draftsHandler saves and deletes the drafts posted from the compose form and
+// loads them back in the form.
+func draftsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	if r.Method == http.MethodPost && r.URL.Path == "/drafts" {
+		kind, name := r.PostFormValue("kind"), r.PostFormValue("name")
+		if r.PostFormValue("delete") != "" {
+			if err := drafts.Delete(kind, name); err != nil {
+				http.Error(w, fmt.Sprintf("The %s could not be deleted: %s", kind, err), http.StatusBadRequest)
+				return
+			}
+			log.Printf("Draft deleted: kind=%s name=%s", kind, name)
+			redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if err := drafts.Save(kind, name, r.PostFormValue("message")); err != nil {
+			http.Error(w, fmt.Sprintf("The %s could not be saved: %s", kind, err), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Draft saved: kind=%s name=%s", kind, name)
+		redirect(w, r, fmt.Sprintf("/drafts/%s/%s", kind, name), http.StatusSeeOther)
+		return
+	}
+
+	values := draftsRe.FindStringSubmatch(r.URL.Path)
+	if len(values) < 3 {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	d, ok := drafts.Get(values[1], values[2])
+	if !ok {
+		http.Error(w, fmt.Sprintf("The %s does not exist.", values[1]), http.StatusNotFound)
+		return
+	}
+	renderForm(w, r, &Page{
+		MessageType:    d.MessageType,
+		DefaultMessage: d.Body,
+		Draft:          &d,
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Previewer is implemented by the publishers that can describe the record
+// that they would publish without sending it, e.g. the Kinesis publisher
+// chooses the partition key and checks the size of the record.
+type Previewer interface {
+	Preview(ctx context.Context, data []byte) (*receipt, error)
+}
+
+type dryRunKey struct{}
+
+// withDryRun returns a context that overrides -dry-run, e.g. for the toggle
+// of the compose form.
+func withDryRun(ctx context.Context, dryRun bool) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, dryRun)
+}
+
+// isDryRun reports whether the messages should be previewed instead of sent.
+func isDryRun(ctx context.Context) bool {
+	if dryRun, ok := ctx.Value(dryRunKey{}).(bool); ok {
+		return dryRun
+	}
+	return *dryRun
+}
+
+// previewMessage returns the receipt of a message that was not sent.
+func previewMessage(ctx context.Context, data []byte) (*receipt, error) {
+	if p, ok := activeConnection().publisher.(Previewer); ok {
+		return p.Preview(ctx, data)
+	}
+	return &receipt{
+		Detail: fmt.Sprintf("Dry run, the %s publisher would have sent it", *publisherName),
+		Data:   data,
+		Size:   len(data),
+		DryRun: true,
+	}, nil
+}
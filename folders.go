@@ -0,0 +1,152 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const bucketsHTML = `{{template "header" .}}
+		<h3>Buckets</h3>
+		{{if .Error}}
+			<div class="error"><p>{{.Error}}</p></div>
+		{{else}}
+			<ul>
+				{{range .Buckets}}
+					<li><a href="{{url "/folders/"}}{{.Name}}/">{{.Name}}</a>{{if .CreationDate}} <small>created {{.CreationDate.Format "2006-01-02"}}</small>{{end}}</li>
+				{{else}}
+					<li>No buckets found.</li>
+				{{end}}
+			</ul>
+		{{end}}
+{{template "footer" .}}`
+
+const foldersHTML = `{{template "header" .}}
+		<h3>
+			<a href="{{url "/buckets"}}">Buckets</a> /
+			<a href="{{url "/folders/"}}{{.Bucket}}/">{{.Bucket}}</a> /
+			{{range .Breadcrumbs}}<a href="{{url "/folders/"}}{{$.Bucket}}/{{.Prefix}}">{{.Name}}</a> / {{end}}
+		</h3>
+		{{if .Error}}
+			<div class="error"><p>{{.Error}}</p></div>
+		{{else}}
+			<p>
+				<a href="{{url "/with-files/"}}{{.Bucket}}/{{.Prefix}}" class="button">Compose message</a>
+				<a href="{{url "/browse/"}}{{.Bucket}}/{{.Prefix}}" class="button button-outline">Pick files</a>
+			</p>
+			<table>
+				<thead>
+					<tr><th>Name</th><th>Size</th><th>Last modified</th></tr>
+				</thead>
+				<tbody>
+					{{range .Folders}}
+						<tr><td><a href="{{url "/folders/"}}{{$.Bucket}}/{{.Prefix}}">{{.Name}}/</a></td><td></td><td></td></tr>
+					{{end}}
+					{{range .Objects}}
+						<tr><td>{{.Name}}</td><td>{{.Size}}</td><td>{{.LastModified}}</td></tr>
+					{{end}}
+				</tbody>
+			</table>
+			{{if not (or .Folders .Objects)}}<p>This folder is empty.</p>{{end}}
+		{{end}}
+{{template "footer" .}}`
+
+var (
+	bucketsTmpl = template.Must(template.Must(template.New("buckets").Funcs(layoutFuncs).Parse(bucketsHTML)).Parse(layout))
+	foldersTmpl = template.Must(template.Must(template.New("folders").Funcs(layoutFuncs).Parse(foldersHTML)).Parse(layout))
+)
+
+func bucketsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	data := struct {
+		Buckets []*s3.Bucket
+		Error   string
+	}{}
+	resp, err := activeConnection().s3.ListBuckets(&s3.ListBucketsInput{})
+	if err != nil {
+		log.Printf("[ERROR] S3 not available! - %s", err)
+		data.Error = "An error occurred trying to access S3! See the logs for more details."
+	} else {
+		data.Buckets = resp.Buckets
+	}
+
+	if err := executeTemplate(w, r, bucketsTmpl, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// folderEntry is a link in the folder view.
+type folderEntry struct {
+	Name   string
+	Prefix string
+}
+
+type objectEntry struct {
+	Name         string
+	Size         int64
+	LastModified string
+}
+
+func foldersHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	query := strings.TrimPrefix(r.URL.Path, "/folders/")
+	if query == "" {
+		redirect(w, r, "/buckets", http.StatusFound)
+		return
+	}
+	bucket, keyPrefix := splitBucketPrefix(query)
+
+	data := struct {
+		Bucket      string
+		Prefix      string
+		Breadcrumbs []folderEntry
+		Folders     []folderEntry
+		Objects     []objectEntry
+		Error       string
+	}{
+		Bucket: bucket,
+		Prefix: keyPrefix,
+	}
+
+	var current string
+	for _, name := range strings.Split(strings.TrimSuffix(keyPrefix, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		current += name + "/"
+		data.Breadcrumbs = append(data.Breadcrumbs, folderEntry{Name: name, Prefix: current})
+	}
+
+	log.Printf("Accessing to S3, bucket=%s prefix=%s delimiter=/", bucket, keyPrefix)
+	folders, objects, err := listFolder(bucket, keyPrefix)
+	if err != nil {
+		log.Printf("[ERROR] S3 not available! bucket=%s prefix=%s - %s", bucket, keyPrefix, err)
+		data.Error = "An error occurred trying to access S3! See the logs for more details."
+	}
+	for _, folder := range folders {
+		data.Folders = append(data.Folders, folderEntry{
+			Name:   path.Base(folder),
+			Prefix: folder,
+		})
+	}
+	for _, object := range objects {
+		entry := objectEntry{Name: path.Base(*object.Key)}
+		if object.Size != nil {
+			entry.Size = *object.Size
+		}
+		if object.LastModified != nil {
+			entry.LastModified = object.LastModified.Format("2006-01-02 15:04:05")
+		}
+		data.Objects = append(data.Objects, entry)
+	}
+
+	if err := executeTemplate(w, r, foldersTmpl, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
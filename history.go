@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyEntry is a message published by msgcreator.
+type historyEntry struct {
+	ID             int       `json:"id"`
+	SentAt         time.Time `json:"sentAt"`
+	Target         string    `json:"target"`
+	Publisher      string    `json:"publisher"`
+	Stream         string    `json:"stream"`
+	MessageID      string    `json:"messageId"`
+	MessageType    string    `json:"messageType"`
+	ObjectUUID     string    `json:"objectUuid"`
+	ShardID        string    `json:"shardId"`
+	SequenceNumber string    `json:"sequenceNumber"`
+	Detail         string    `json:"detail"`
+	Body           string    `json:"body"` // As it was sent, byte for byte.
+}
+
+// Tracked returns the message followed by the tracker if it is the one that
+// was sent by this entry, i.e. it was not resent with the same ID later.
+func (e historyEntry) Tracked() *sentMessage {
+	m, ok := tracker.Get(e.MessageID)
+	if !ok || m.Receipt == nil || m.Receipt.SequenceNumber != e.SequenceNumber {
+		return nil
+	}
+	return &m
+}
+
+// IndentedBody returns the body indented for display, the messages are sent
+// compacted.
+func (e historyEntry) IndentedBody() string {
+	buf := &bytes.Buffer{}
+	if err := json.Indent(buf, []byte(e.Body), "", "  "); err != nil {
+		return e.Body
+	}
+	return buf.String()
+}
+
+// matches reports whether all the terms of the query are found in the entry,
+// the body included. The terms must be lowercase.
+func (e historyEntry) matches(terms []string) bool {
+	fields := strings.ToLower(strings.Join([]string{
+		e.Target, e.Publisher, e.Stream, e.MessageID, e.MessageType, e.ObjectUUID, e.ShardID, e.SequenceNumber, e.Body,
+	}, "\n"))
+	for _, term := range terms {
+		if !strings.Contains(fields, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// historyStore is a persistent log of the messages sent. Entries are appended
+// to a JSONL file, one entry per line, and the most recent ones are loaded in
+// memory at startup.
+type historyStore struct {
+	path    string
+	max     int // Entries kept, 0 keeps all of them.
+	entries []*historyEntry
+	lines   int // Entries in the file, including the ones trimmed in memory.
+	mu      sync.RWMutex
+}
+
+// newHistoryStore loads the history persisted in path, keeping the max most
+// recent entries. An empty path gives an in-memory history.
+func newHistoryStore(path string, max int) (*historyStore, error) {
+	h := &historyStore{path: path, max: max}
+	if path == "" {
+		return h, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		entry := &historyEntry{}
+		if err := json.Unmarshal(line, entry); err != nil {
+			// The last line may be truncated if we were killed while writing.
+			log.Printf("[ERROR] History entry ignored (path=%s line=%d): %s", path, n, err)
+			continue
+		}
+		h.entries = append(h.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("history %s could not be read: %s", path, err)
+	}
+	h.lines = len(h.entries)
+	h.trim()
+	if h.lines > len(h.entries) {
+		if err := h.rewrite(); err != nil {
+			return nil, fmt.Errorf("history %s could not be trimmed: %s", path, err)
+		}
+	}
+	return h, nil
+}
+
+// Add records a message that was just published.
+func (h *historyStore) Add(blob []byte, rcpt *receipt) error {
+	entry := &historyEntry{
+		SentAt:         time.Now(),
+		Target:         activeTarget().Name,
+		Publisher:      *publisherName,
+		Stream:         rcpt.Stream,
+		MessageType:    messageType(blob),
+		ShardID:        rcpt.ShardID,
+		SequenceNumber: rcpt.SequenceNumber,
+		Detail:         rcpt.Detail,
+		Body:           string(blob),
+	}
+	entry.MessageID, entry.ObjectUUID = recordIdentifiers(blob)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry.ID = 1
+	if len(h.entries) > 0 {
+		entry.ID = h.entries[len(h.entries)-1].ID + 1
+	}
+	h.entries = append(h.entries, entry)
+	h.trim()
+	if h.path == "" {
+		return nil
+	}
+	// The file is rewritten once in a while rather than on every message sent
+	// when the history is full.
+	if h.max > 0 && h.lines >= h.max+h.max/10 {
+		return h.rewrite()
+	}
+	if err := h.append(entry); err != nil {
+		return err
+	}
+	h.lines++
+	return nil
+}
+
+// trim forgets the oldest entries beyond the maximum. The caller must hold
+// the lock.
+func (h *historyStore) trim() {
+	if h.max > 0 && len(h.entries) > h.max {
+		h.entries = append([]*historyEntry(nil), h.entries[len(h.entries)-h.max:]...)
+	}
+}
+
+// rewrite replaces the file with the entries kept in memory. The caller must
+// hold the lock.
+func (h *historyStore) rewrite() error {
+	buf := &bytes.Buffer{}
+	for _, entry := range h.entries {
+		blob, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(blob, '\n'))
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		return err
+	}
+	h.lines = len(h.entries)
+	return nil
+}
+
+// append writes an entry to disk. The caller must hold the lock.
+func (h *historyStore) append(entry *historyEntry) error {
+	blob, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(blob, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Get returns a copy of the entry with the given ID.
+func (h *historyStore) Get(id int) (historyEntry, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, entry := range h.entries {
+		if entry.ID == id {
+			return *entry, true
+		}
+	}
+	return historyEntry{}, false
+}
+
+// Search returns copies of up to limit entries that match the query, the
+// most recent first, and the number of entries found.
+func (h *historyStore) Search(query string, limit int) ([]historyEntry, int) {
+	terms := strings.Fields(strings.ToLower(query))
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	found := []historyEntry{}
+	total := 0
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		if !h.entries[i].matches(terms) {
+			continue
+		}
+		total++
+		if len(found) < limit {
+			found = append(found, *h.entries[i])
+		}
+	}
+	return found, total
+}
+
+type noHistoryKey struct{}
+
+// withoutHistory returns a context whose messages are not added to the
+// history, e.g. the synthetic traffic of a load test.
+func withoutHistory(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noHistoryKey{}, true)
+}
+
+// recordSent is called for every message published, it keeps the message in
+// the history and follows it until the adapter reports it or the grace period
+// elapses.
+func recordSent(ctx context.Context, blob []byte, rcpt *receipt) {
+	tracker.Track(blob, rcpt)
+	if skip, _ := ctx.Value(noHistoryKey{}).(bool); skip {
+		return
+	}
+	if err := sendHistory.Add(blob, rcpt); err != nil {
+		log.Printf("[ERROR] The message could not be added to the history: %s", err)
+	}
+}
+
+// withNewIDs returns the message with a new messageId and messageSequence.
+func withNewIDs(blob []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(blob))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	header, ok := doc["messageHeader"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the message doesn't have a header")
+	}
+	regenerateIDs(header, make(map[string]string))
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// maxHistoryResults is the number of entries listed per search.
+const maxHistoryResults = 200
+
+const historyHTML = `{{template "header" .}}
+		<h3>History</h3>
+		<p>{{if .Path}}Every message sent is recorded in <code>{{.Path}}</code>{{else}}The history is kept in memory, use <code>-history</code> to persist it{{end}}. The search looks for all the words given in the identifiers, the stream and the body of the messages.</p>
+		<form method="GET" class="row">
+			<div class="column column-75"><input type="search" name="q" value="{{.Query}}" placeholder="e.g. MetadataCreate 5680e8e0" /></div>
+			<div class="column"><button type="submit" class="button button-outline">Search</button></div>
+		</form>
+		<p>{{.Total}} message(s) found{{if gt .Total (len .Entries)}}, the most recent {{len .Entries}} are listed{{end}}.</p>
+		<table>
+			<thead>
+				<tr><th>Sent</th><th>Message</th><th>Stream</th><th>ShardId / SequenceNumber</th><th>Status</th></tr>
+			</thead>
+			<tbody>
+				{{range .Entries}}
+					<tr>
+						<td><a href="{{url "/history/"}}{{.ID}}">{{.SentAt.Format "2006-01-02 15:04:05"}}</a><br /><small>{{.Target}}</small></td>
+						<td><code>{{.MessageType}}</code><br /><small>{{.MessageID}}{{if .ObjectUUID}}<br />objectUuid: {{.ObjectUUID}}{{end}}</small></td>
+						<td>{{if .Stream}}{{.Stream}}{{else}}{{.Publisher}}{{end}}</td>
+						<td><small>{{.ShardID}}<br />{{.SequenceNumber}}</small></td>
+						<td>{{with .Tracked}}{{.Status}}{{if .ErrorCode}}<br /><small><code>{{.ErrorCode}}</code> {{.ErrorDescription}}</small>{{end}}{{end}}</td>
+					</tr>
+				{{else}}
+					<tr><td colspan="5">No messages found.</td></tr>
+				{{end}}
+			</tbody>
+		</table>
+{{template "footer" .}}`
+
+const historyEntryHTML = `{{template "header" .}}
+		<h3>{{.MessageType}} sent at {{.SentAt.Format "2006-01-02 15:04:05"}}</h3>
+		{{$tracked := .Tracked}}
+		<div class="{{if and $tracked $tracked.ErrorCode}}error{{else}}result{{end}}">
+			messageId: <code>{{.MessageID}}</code>
+			{{if .ObjectUUID}}<br />objectUuid: <code>{{.ObjectUUID}}</code>{{end}}
+			<br />Target: {{.Target}} &middot; Publisher: {{.Publisher}}{{if .Stream}} &middot; Stream: {{.Stream}}{{end}}
+			{{if .SequenceNumber}}<br />ShardId: {{.ShardID}} &middot; SequenceNumber: {{.SequenceNumber}}{{end}}
+			{{if .Detail}}<br />{{.Detail}}{{end}}
+			{{with $tracked}}
+				<br /><a href="{{url "/sent/"}}{{.MessageID}}">{{.Status}}</a>{{if .ErrorCode}}: the channel adapter sent the message back to <code>{{.Queue}}</code>, <code>{{.ErrorCode}}</code> {{.ErrorDescription}}{{end}}
+			{{end}}
+			{{if and .Stream .SequenceNumber}}<br /><a href="{{url "/streams"}}?stream={{.Stream}}&amp;shard={{.ShardID}}&amp;type=AT_SEQUENCE_NUMBER&amp;sequence={{.SequenceNumber}}&amp;limit=1">View the record in the stream</a>{{end}}
+		</div>
+		<form method="POST" action="{{url "/history/"}}{{.ID}}/resend">
+			<p><input type="checkbox" name="dry-run" id="dry-run" value="true"{{if $.DryRun}} checked{{end}} /><label class="label-inline" for="dry-run">Dry run, show what would be published without sending it</label></p>
+			<button type="submit" name="ids" value="keep" class="button">Resend as-is</button>
+			<button type="submit" name="ids" value="regenerate" class="button button-outline" title="New messageId and messageSequence">Resend with new IDs</button>
+			<a href="{{url "/history/"}}{{.ID}}/edit" class="button button-outline">Edit and resend</a>
+		</form>
+		<pre><code>{{.IndentedBody}}</code></pre>
+		<a href="{{url "/history"}}">All the messages sent</a>
+{{template "footer" .}}`
+
+var (
+	historyTmpl      = template.Must(template.Must(template.New("history").Funcs(layoutFuncs).Parse(historyHTML)).Parse(layout))
+	historyEntryTmpl = template.Must(template.Must(template.New("historyEntry").Funcs(layoutFuncs).Parse(historyEntryHTML)).Parse(layout))
+	historyRe        = regexp.MustCompile("^/history/([0-9]+)(/edit|/resend)?$")
+
+	sendHistory *historyStore
+)
+
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	if r.URL.Path == "/history" || r.URL.Path == "/history/" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query().Get("q")
+		entries, total := sendHistory.Search(query, maxHistoryResults)
+		err := executeTemplate(w, r, historyTmpl, struct {
+			Path    string
+			Query   string
+			Total   int
+			Entries []historyEntry
+		}{sendHistory.path, query, total, entries})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	values := historyRe.FindStringSubmatch(r.URL.Path)
+	if len(values) < 2 {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	id, _ := strconv.Atoi(values[1])
+	entry, ok := sendHistory.Get(id)
+	if !ok {
+		http.Error(w, "The message is not in the history.", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case values[2] == "/resend" && r.Method == http.MethodPost:
+		msg := []byte(entry.Body)
+		if r.PostFormValue("ids") == "regenerate" {
+			var err error
+			if msg, err = withNewIDs(msg); err != nil {
+				http.Error(w, fmt.Sprintf("The IDs of the message could not be regenerated: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+		log.Printf("Resending message from the history: id=%d ids=%s", entry.ID, r.PostFormValue("ids"))
+		submitMessage(w, r, string(msg), r.PostFormValue("dry-run") != "", *splitMessages)
+	case values[2] == "/edit" && r.Method == http.MethodGet:
+		renderForm(w, r, &Page{
+			MessageType:    entry.MessageType,
+			DefaultMessage: entry.IndentedBody(),
+		})
+	case values[2] == "" && r.Method == http.MethodGet:
+		err := executeTemplate(w, r, historyEntryTmpl, struct {
+			historyEntry
+			DryRun bool
+		}{entry, *dryRun})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistoryRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.jsonl")
+
+	defer func(name *string) { publisherName = name }(publisherName)
+	name := "stdout"
+	publisherName = &name
+
+	h, err := newHistoryStore(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 25; i++ {
+		blob := []byte(fmt.Sprintf(`{"messageHeader": {"messageId": "m%d"}}`, i))
+		if err := h.Add(blob, &receipt{}); err != nil {
+			t.Fatal(err)
+		}
+		if lines := countLines(t, path); lines > 11 {
+			t.Fatalf("the file has %d entries after %d messages, the limit is 10", lines, i)
+		}
+	}
+	checkHistoryIDs(t, h, 16, 25)
+
+	// A smaller limit trims the file when it is loaded.
+	if h, err = newHistoryStore(path, 5); err != nil {
+		t.Fatal(err)
+	}
+	checkHistoryIDs(t, h, 21, 25)
+	if lines := countLines(t, path); lines != 5 {
+		t.Errorf("the file has %d entries, want 5", lines)
+	}
+}
+
+func TestLoadTestIsNotRecorded(t *testing.T) {
+	defer func(h *historyStore, name *string) { sendHistory, publisherName = h, name }(sendHistory, publisherName)
+	name := "stdout"
+	sendHistory, publisherName = &historyStore{}, &name
+
+	recordSent(withoutHistory(context.Background()), []byte(`{"messageHeader": {"messageId": "load"}}`), &receipt{})
+	recordSent(context.Background(), []byte(`{"messageHeader": {"messageId": "manual"}}`), &receipt{})
+	entries, total := sendHistory.Search("", maxHistoryResults)
+	if total != 1 || entries[0].MessageID != "manual" {
+		t.Errorf("got %d entries in the history, want only the manual message", total)
+	}
+}
+
+func checkHistoryIDs(t *testing.T, h *historyStore, first, last int) {
+	t.Helper()
+	entries, total := h.Search("", maxHistoryResults)
+	if total != last-first+1 {
+		t.Fatalf("got %d entries, want %d", total, last-first+1)
+	}
+	for i, entry := range entries {
+		if entry.ID != last-i {
+			t.Errorf("entry %d has ID %d, want %d", i, entry.ID, last-i)
+		}
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(blob, []byte("\n"))
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
+)
+
+// fileTasks is the queue of the worker pool that turns S3 objects into files.
+// The pool is shared by all the requests so the number of concurrent HEAD and
+// GET requests sent to S3 is bounded by the number of workers.
+var fileTasks chan func()
+
+// startWorkers starts the worker pool.
+func startWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	fileTasks = make(chan func())
+	for i := 0; i < n; i++ {
+		go func() {
+			for task := range fileTasks {
+				task()
+			}
+		}()
+	}
+}
+
+// buildFile turns a listed S3 object into a file entry.
+func buildFile(client *s3.S3, bucket string, object *s3.Object, withChecksums bool) *message.File {
+	var sums []checksum
+	if withChecksums {
+		sums = hasher(client, bucket, *object.Key, *object.ETag)
+	}
+	return createFile(
+		fmt.Sprintf("%s", message.NewUUID()),
+		fmt.Sprintf("s3://%s/%s", bucket, *object.Key),
+		*object.Key,
+		sums,
+		describeObject(client, bucket, object),
+	)
+}
+
+// buildFiles turns the listed S3 objects into file entries using the worker
+// pool. The order of the objects is preserved. progress, if not nil, is called
+// every time that an object is processed.
+func buildFiles(client *s3.S3, bucket string, objects []*s3.Object, withChecksums bool, progress func()) []message.File {
+	files := make([]message.File, len(objects))
+	var wg sync.WaitGroup
+	wg.Add(len(objects))
+	for i, object := range objects {
+		i, object := i, object
+		fileTasks <- func() {
+			defer wg.Done()
+			files[i] = *buildFile(client, bucket, object, withChecksums)
+			if progress != nil {
+				progress()
+			}
+		}
+	}
+	wg.Wait()
+	return files
+}
+
+// job is a MetadataCreate message being populated in the background. The
+// page is rendered once all the files have been processed.
+type job struct {
+	ID      string
+	Total   int
+	Created time.Time
+
+	mu       sync.RWMutex
+	done     int
+	finished bool
+	page     *Page
+}
+
+type jobStatus struct {
+	Done     int  `json:"done"`
+	Total    int  `json:"total"`
+	Finished bool `json:"finished"`
+}
+
+func (j *job) status() jobStatus {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return jobStatus{Done: j.done, Total: j.Total, Finished: j.finished}
+}
+
+func (j *job) progress() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.done++
+}
+
+func (j *job) finish(p *Page) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.page = p
+	j.finished = true
+}
+
+// jobRetention is how long we keep the jobs around after they're created.
+const jobRetention = time.Hour
+
+var (
+	jobs   = make(map[string]*job)
+	jobsMu sync.Mutex
+)
+
+// startFilesJob generates the message requested in the background. The page
+// given is rendered with the resulting message when the job is done.
+func startFilesJob(req generateRequest, objects []*s3.Object, p *Page) *job {
+	j := &job{
+		ID:      message.NewUUID().String(),
+		Total:   len(objects),
+		Created: time.Now(),
+	}
+
+	jobsMu.Lock()
+	for id, item := range jobs {
+		if time.Since(item.Created) > jobRetention {
+			delete(jobs, id)
+		}
+	}
+	jobs[j.ID] = j
+	jobsMu.Unlock()
+
+	go func() {
+		log.Printf("Job started (id=%s bucket=%s objects=%d)", j.ID, req.Bucket, j.Total)
+		var msg []byte
+		m, err := composeRequested(req, objects, j.progress)
+		if err == nil {
+			msg, err = encodeMessage(m)
+		}
+		if err != nil {
+			p.S3Available = false
+			log.Printf("[ERROR] Job failed (id=%s): %s", j.ID, err)
+		}
+		p.DefaultMessage = string(msg)
+		j.finish(p)
+		log.Printf("Job finished (id=%s)", j.ID)
+	}()
+
+	return j
+}
+
+func lookupJob(id string) (*job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j, ok := jobs[id]
+	return j, ok
+}
+
+const jobHTML = `{{template "header" .}}
+		<h3>Processing {{.Total}} objects...</h3>
+		<p>Checksums are being computed in the background, the message will be ready in a moment. <span id="status">{{.Done}} of {{.Total}} objects processed.</span></p>
+		<progress id="progress" value="{{.Done}}" max="{{.Total}}"></progress>
+		<script>
+			var source = new EventSource("{{url "/jobs/"}}{{.ID}}/events");
+			source.addEventListener("progress", function(e) {
+				var status = JSON.parse(e.data);
+				document.getElementById("progress").value = status.done;
+				document.getElementById("status").textContent = status.done + " of " + status.total + " objects processed.";
+			});
+			source.addEventListener("done", function(e) {
+				source.close();
+				window.location.reload();
+			});
+		</script>
+{{template "footer" .}}`
+
+var (
+	jobTmpl = template.Must(template.Must(template.New("job").Funcs(layoutFuncs).Parse(jobHTML)).Parse(layout))
+	jobRe   = regexp.MustCompile("^/jobs/([0-9a-f-]+)(/events)?$")
+)
+
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	values := jobRe.FindStringSubmatch(r.URL.Path)
+	if len(values) < 2 || r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	j, ok := lookupJob(values[1])
+	if !ok {
+		http.Error(w, "The job does not exist or has expired.", http.StatusNotFound)
+		return
+	}
+
+	if values[2] != "" {
+		streamJobEvents(w, r, j)
+		return
+	}
+
+	status := j.status()
+	if status.Finished {
+		// renderForm fills the page, every view gets its own copy.
+		p := *j.page
+		renderForm(w, r, &p)
+		return
+	}
+	err := executeTemplate(w, r, jobTmpl, struct {
+		ID    string
+		Done  int
+		Total int
+	}{j.ID, status.Done, status.Total})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// streamJobEvents reports the progress of the job using server-sent events.
+func streamJobEvents(w http.ResponseWriter, r *http.Request, j *job) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	last := -1
+	for {
+		status := j.status()
+		if status.Done != last {
+			last = status.Done
+			blob, _ := json.Marshal(status)
+			fmt.Fprintf(w, "event: progress\ndata: %s\n\n", blob)
+		}
+		if status.Finished {
+			fmt.Fprint(w, "event: done\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
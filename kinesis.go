@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+
+	"github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
+)
+
+func init() {
+	registerPublisher("kinesis", newKinesisPublisher)
+}
+
+// partitionKeyStrategy chooses the partition key of a record and optionally
+// an explicit hash key, which overrides the partition key when Kinesis maps
+// the record to a shard.
+type partitionKeyStrategy func(data []byte, value string) (partitionKey, hashKey string)
+
+var partitionKeyStrategies = map[string]partitionKeyStrategy{
+	// The current Unix time, i.e. all the messages sent within the same
+	// second land in the same shard.
+	"timestamp": func(data []byte, value string) (string, string) {
+		return strconv.FormatInt(time.Now().Unix(), 10), ""
+	},
+	"message-id": func(data []byte, value string) (string, string) {
+		messageID, _ := recordIdentifiers(data)
+		return messageIDKey(messageID), ""
+	},
+	// The UUID of the research object, e.g. all the messages about the same
+	// dataset land in the same shard. Vocabulary messages don't have one so
+	// the message ID is used instead.
+	"object-uuid": func(data []byte, value string) (string, string) {
+		messageID, objectUUID := recordIdentifiers(data)
+		if objectUUID == "" {
+			messageID = messageIDKey(messageID)
+			log.Printf("Message without objectUuid, using the message ID as the partition key: %s", messageID)
+			return messageID, ""
+		}
+		return objectUUID, ""
+	},
+	"fixed": func(data []byte, value string) (string, string) {
+		return value, ""
+	},
+	"random": func(data []byte, value string) (string, string) {
+		return message.NewUUID().String(), ""
+	},
+	// The value is used as the explicit hash key, a decimal number in the
+	// range of the hash keys of the shard targeted.
+	"hash-key": func(data []byte, value string) (string, string) {
+		messageID, _ := recordIdentifiers(data)
+		return messageIDKey(messageID), value
+	},
+}
+
+// recordIdentifiers returns the message ID and the object UUID found in the
+// message, when available.
+func recordIdentifiers(data []byte) (messageID, objectUUID string) {
+	msg := struct {
+		MessageHeader struct {
+			MessageID string `json:"messageId"`
+		} `json:"messageHeader"`
+		MessageBody struct {
+			ObjectUUID string `json:"objectUuid"`
+		} `json:"messageBody"`
+	}{}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Printf("[ERROR] Message identifiers could not be decoded: %s", err)
+	}
+	return msg.MessageHeader.MessageID, msg.MessageBody.ObjectUUID
+}
+
+// messageIDKey returns the message ID, or a random key when the message
+// doesn't have one because Kinesis requires a partition key.
+func messageIDKey(messageID string) string {
+	if messageID == "" {
+		return message.NewUUID().String()
+	}
+	return messageID
+}
+
+// kinesisMaxPartitionKeyLength is the number of characters accepted in a
+// partition key.
+const kinesisMaxPartitionKeyLength = 256
+
+// hashKeyRe is the pattern of the explicit hash keys in the Kinesis API.
+var hashKeyRe = regexp.MustCompile("^(0|[1-9][0-9]{0,38})$")
+
+// checkPartitionKeyValue returns an error when Kinesis would refuse the value
+// given to a strategy, so we find out before sending anything.
+func checkPartitionKeyValue(strategy, value string) error {
+	switch strategy {
+	case "fixed":
+		if n := utf8.RuneCountInString(value); n < 1 || n > kinesisMaxPartitionKeyLength {
+			return fmt.Errorf("the partition key must have between 1 and %d characters, it has %d", kinesisMaxPartitionKeyLength, n)
+		}
+	case "hash-key":
+		n, ok := new(big.Int).SetString(value, 10)
+		if !hashKeyRe.MatchString(value) || !ok || n.BitLen() > 128 {
+			return fmt.Errorf("the explicit hash key must be a decimal integer between 0 and 2^128-1, got %q", value)
+		}
+	}
+	return nil
+}
+
+func partitionKeyStrategyNames() []string {
+	names := []string{}
+	for name := range partitionKeyStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// kinesisPublisher puts the messages in a Kinesis stream. Options: "stream",
+// "partition-key" and "partition-key-value", which default to the values of
+// the -kinesis-* flags.
+type kinesisPublisher struct {
+	client            *kinesis.Kinesis
+	stream            string
+	partitionKey      partitionKeyStrategy
+	partitionKeyValue string
+}
+
+func newKinesisPublisher(c *connection, opts map[string]string) (Publisher, error) {
+	p := &kinesisPublisher{
+		client:            c.kinesis,
+		stream:            c.KinesisStream,
+		partitionKeyValue: *kinesisPartitionKeyValue,
+	}
+	if stream, ok := opts["stream"]; ok {
+		p.stream = stream
+	}
+	if value, ok := opts["partition-key-value"]; ok {
+		p.partitionKeyValue = value
+	}
+	name := *kinesisPartitionKey
+	if value, ok := opts["partition-key"]; ok {
+		name = value
+	}
+	strategy, ok := partitionKeyStrategies[name]
+	if !ok {
+		return nil, fmt.Errorf("kinesis publisher: unknown partition key strategy %q, choose one of: %s", name, strings.Join(partitionKeyStrategyNames(), ", "))
+	}
+	if (name == "fixed" || name == "hash-key") && p.partitionKeyValue == "" {
+		return nil, fmt.Errorf("kinesis publisher: partition key strategy %q requires a value", name)
+	}
+	if err := checkPartitionKeyValue(name, p.partitionKeyValue); err != nil {
+		return nil, fmt.Errorf("kinesis publisher: %s", err)
+	}
+	p.partitionKey = strategy
+	return p, nil
+}
+
+// recordSizeError is returned when a record exceeds the maximum size accepted
+// by Kinesis.
+type recordSizeError struct {
+	size int
+}
+
+func (e *recordSizeError) Error() string {
+	return fmt.Sprintf("the record is too large for Kinesis: %d bytes, the limit is %d bytes (messages with several files can be split, see -split-messages)", e.size, kinesisMaxRecordSize)
+}
+
+// checkRecordSize returns an error when the record exceeds the maximum size
+// accepted by Kinesis, i.e. the data plus the partition key.
+func checkRecordSize(data []byte, partitionKey string) error {
+	if size := len(data) + len(partitionKey); size > kinesisMaxRecordSize {
+		return &recordSizeError{size: size}
+	}
+	return nil
+}
+
+// Preview chooses the partition key and checks the size of the record like
+// Publish does, but the record is not sent.
+func (p *kinesisPublisher) Preview(ctx context.Context, data []byte) (*receipt, error) {
+	partitionKey, hashKey := p.partitionKey(data, p.partitionKeyValue)
+	if err := checkRecordSize(data, partitionKey); err != nil {
+		return nil, err
+	}
+	return &receipt{
+		PartitionKey:    partitionKey,
+		ExplicitHashKey: hashKey,
+		Stream:          p.stream,
+		Detail:          fmt.Sprintf("Dry run, the record was not put in the Kinesis stream %s", p.stream),
+		DryRun:          true,
+		Data:            data,
+		Size:            len(data) + len(partitionKey),
+	}, nil
+}
+
+func (p *kinesisPublisher) Publish(ctx context.Context, data []byte) (*receipt, error) {
+	partitionKey, hashKey := p.partitionKey(data, p.partitionKeyValue)
+	if err := checkRecordSize(data, partitionKey); err != nil {
+		return nil, err
+	}
+	req := &kinesis.PutRecordInput{
+		Data:         data,
+		StreamName:   aws.String(p.stream),
+		PartitionKey: aws.String(partitionKey),
+	}
+	if hashKey != "" {
+		req.ExplicitHashKey = aws.String(hashKey)
+	}
+	resp, err := p.client.PutRecordWithContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &receipt{
+		ShardID:         *resp.ShardId,
+		SequenceNumber:  *resp.SequenceNumber,
+		PartitionKey:    partitionKey,
+		ExplicitHashKey: hashKey,
+		Stream:          p.stream,
+		Detail:          fmt.Sprintf("Kinesis stream %s", p.stream),
+	}, nil
+}
+
+// Limits of the PutRecords API.
+const (
+	kinesisMaxBatchRecords = 500
+	kinesisMaxBatchSize    = 5 << 20
+	kinesisMaxRecordSize   = 1 << 20
+)
+
+// PublishBatch puts the messages with PutRecords in chunks that respect the
+// limits of the API. Only the records that fail, e.g. because the throughput
+// of the shard was exceeded, are retried, with an exponential backoff. The
+// records too large for Kinesis fail without being sent, like Preview does,
+// otherwise they would make the whole chunk fail.
+func (p *kinesisPublisher) PublishBatch(ctx context.Context, records []*batchRecord, data [][]byte) {
+	entries := make([]*kinesis.PutRecordsRequestEntry, len(records))
+	pending := []int{}
+	for i := range records {
+		partitionKey, hashKey := p.partitionKey(data[i], p.partitionKeyValue)
+		if err := checkRecordSize(data[i], partitionKey); err != nil {
+			records[i].ErrorCode = "RecordTooLarge"
+			records[i].Error = err.Error()
+			continue
+		}
+		entries[i] = &kinesis.PutRecordsRequestEntry{
+			Data:         data[i],
+			PartitionKey: aws.String(partitionKey),
+		}
+		if hashKey != "" {
+			entries[i].ExplicitHashKey = aws.String(hashKey)
+		}
+		pending = append(pending, i)
+	}
+
+	for attempt := 0; len(pending) > 0 && attempt <= *kinesisMaxRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Retrying %d failed record(s), attempt %d", len(pending), attempt+1)
+			select {
+			case <-time.After(time.Duration(100<<uint(attempt-1)) * time.Millisecond):
+			case <-ctx.Done():
+				return
+			}
+		}
+		failed := []int{}
+		for _, chunk := range p.chunk(entries, pending) {
+			failed = append(failed, p.putRecords(ctx, records, entries, chunk)...)
+		}
+		pending = failed
+	}
+}
+
+// chunk splits the records given in groups that fit in a PutRecords request.
+func (p *kinesisPublisher) chunk(entries []*kinesis.PutRecordsRequestEntry, indexes []int) [][]int {
+	chunks := [][]int{}
+	current := []int{}
+	size := 0
+	for _, i := range indexes {
+		n := len(entries[i].Data) + len(*entries[i].PartitionKey)
+		if len(current) == kinesisMaxBatchRecords || (len(current) > 0 && size+n > kinesisMaxBatchSize) {
+			chunks = append(chunks, current)
+			current, size = []int{}, 0
+		}
+		current = append(current, i)
+		size += n
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// putRecords sends a chunk and returns the indexes of the records that failed.
+func (p *kinesisPublisher) putRecords(ctx context.Context, records []*batchRecord, entries []*kinesis.PutRecordsRequestEntry, chunk []int) []int {
+	req := &kinesis.PutRecordsInput{StreamName: aws.String(p.stream)}
+	for _, i := range chunk {
+		records[i].Attempts++
+		req.Records = append(req.Records, entries[i])
+	}
+	resp, err := p.client.PutRecordsWithContext(ctx, req)
+	if err != nil {
+		log.Printf("[ERROR] PutRecords failed (stream=%s records=%d): %s", p.stream, len(chunk), err)
+		for _, i := range chunk {
+			records[i].ErrorCode = "RequestFailed"
+			records[i].Error = err.Error()
+		}
+		return chunk
+	}
+	failed := []int{}
+	for n, result := range resp.Records {
+		i := chunk[n]
+		if result.ErrorCode != nil {
+			records[i].ErrorCode = *result.ErrorCode
+			records[i].Error = aws.StringValue(result.ErrorMessage)
+			failed = append(failed, i)
+			continue
+		}
+		records[i].ErrorCode, records[i].Error = "", ""
+		records[i].Receipt = &receipt{
+			ShardID:         aws.StringValue(result.ShardId),
+			SequenceNumber:  aws.StringValue(result.SequenceNumber),
+			PartitionKey:    *entries[i].PartitionKey,
+			ExplicitHashKey: aws.StringValue(entries[i].ExplicitHashKey),
+			Stream:          p.stream,
+			Detail:          fmt.Sprintf("Kinesis stream %s", p.stream),
+		}
+	}
+	return failed
+}
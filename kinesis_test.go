@@ -0,0 +1,165 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+func TestChunk(t *testing.T) {
+	const key = "0123456789" // Counted in the size of the records.
+	tests := []struct {
+		name   string
+		sizes  []int // Data of the records.
+		chunks []int // Records per chunk.
+	}{
+		{
+			name:   "empty",
+			chunks: []int{},
+		},
+		{
+			name:   "single record",
+			sizes:  repeatSize(1, 100),
+			chunks: []int{1},
+		},
+		{
+			name:   "500 records",
+			sizes:  repeatSize(kinesisMaxBatchRecords, 100),
+			chunks: []int{500},
+		},
+		{
+			name:   "501 records",
+			sizes:  repeatSize(kinesisMaxBatchRecords+1, 100),
+			chunks: []int{500, 1},
+		},
+		{
+			name:   "1200 records",
+			sizes:  repeatSize(1200, 100),
+			chunks: []int{500, 500, 200},
+		},
+		{
+			name:   "exactly 5 MiB",
+			sizes:  repeatSize(5, 1<<20-len(key)),
+			chunks: []int{5},
+		},
+		{
+			name:   "one byte over 5 MiB",
+			sizes:  append(repeatSize(4, 1<<20-len(key)), 1<<20-len(key)+1),
+			chunks: []int{4, 1},
+		},
+		{
+			name:   "both limits",
+			sizes:  append(repeatSize(6, 1<<20-len(key)), repeatSize(600, 10)...),
+			chunks: []int{5, 500, 101},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			entries := make([]*kinesis.PutRecordsRequestEntry, len(tc.sizes))
+			indexes := make([]int, len(tc.sizes))
+			for i, size := range tc.sizes {
+				entries[i] = &kinesis.PutRecordsRequestEntry{
+					Data:         make([]byte, size),
+					PartitionKey: aws.String(key),
+				}
+				indexes[i] = i
+			}
+			chunks := (&kinesisPublisher{}).chunk(entries, indexes)
+			if len(chunks) != len(tc.chunks) {
+				t.Fatalf("got %d chunks, want %d", len(chunks), len(tc.chunks))
+			}
+			next := 0
+			for i, chunk := range chunks {
+				if len(chunk) != tc.chunks[i] {
+					t.Errorf("chunk %d has %d records, want %d", i+1, len(chunk), tc.chunks[i])
+				}
+				size := 0
+				for _, index := range chunk {
+					if index != next {
+						t.Fatalf("chunk %d has record %d, want %d", i+1, index, next)
+					}
+					next++
+					size += len(entries[index].Data) + len(*entries[index].PartitionKey)
+				}
+				if size > kinesisMaxBatchSize {
+					t.Errorf("chunk %d is %d bytes, the limit is %d", i+1, size, kinesisMaxBatchSize)
+				}
+			}
+		})
+	}
+}
+
+func repeatSize(n, size int) []int {
+	sizes := make([]int, n)
+	for i := range sizes {
+		sizes[i] = size
+	}
+	return sizes
+}
+
+func TestCheckPartitionKeyValue(t *testing.T) {
+	tests := []struct {
+		strategy string
+		value    string
+		valid    bool
+	}{
+		{"fixed", "dataset-1", true},
+		{"fixed", strings.Repeat("k", kinesisMaxPartitionKeyLength), true},
+		{"fixed", strings.Repeat("é", kinesisMaxPartitionKeyLength), true},
+		{"fixed", "", false},
+		{"fixed", strings.Repeat("k", kinesisMaxPartitionKeyLength+1), false},
+		{"hash-key", "0", true},
+		{"hash-key", "170141183460469231731687303715884105728", true},
+		{"hash-key", "340282366920938463463374607431768211455", true}, // 2^128-1
+		{"hash-key", "340282366920938463463374607431768211456", false},
+		{"hash-key", "999999999999999999999999999999999999999", false},
+		{"hash-key", "", false},
+		{"hash-key", "-1", false},
+		{"hash-key", "+1", false},
+		{"hash-key", "007", false},
+		{"hash-key", "0x10", false},
+		{"hash-key", "1e10", false},
+		{"hash-key", " 1", false},
+		{"timestamp", "", true},
+		{"random", "ignored", true},
+	}
+	for _, tc := range tests {
+		err := checkPartitionKeyValue(tc.strategy, tc.value)
+		if tc.valid && err != nil {
+			t.Errorf("%s %q: unexpected error: %s", tc.strategy, tc.value, err)
+		} else if !tc.valid && err == nil {
+			t.Errorf("%s %q: the value was accepted", tc.strategy, tc.value)
+		}
+	}
+}
+
+func TestPartitionKeyStrategies(t *testing.T) {
+	data := []byte(`{"messageHeader": {"messageId": "m1"}, "messageBody": {"objectUuid": "o1"}}`)
+	vocabulary := []byte(`{"messageHeader": {"messageId": "m2"}, "messageBody": {}}`)
+	tests := []struct {
+		strategy string
+		data     []byte
+		value    string
+		key      string // Empty if it is random.
+		hashKey  string
+	}{
+		{"message-id", data, "", "m1", ""},
+		{"object-uuid", data, "", "o1", ""},
+		{"object-uuid", vocabulary, "", "m2", ""},
+		{"fixed", data, "dataset-1", "dataset-1", ""},
+		{"hash-key", data, "42", "m1", "42"},
+		{"random", data, "", "", ""},
+		{"timestamp", data, "", "", ""},
+	}
+	for _, tc := range tests {
+		key, hashKey := partitionKeyStrategies[tc.strategy](tc.data, tc.value)
+		if key == "" || (tc.key != "" && key != tc.key) {
+			t.Errorf("%s: got partition key %q, want %q", tc.strategy, key, tc.key)
+		}
+		if hashKey != tc.hashKey {
+			t.Errorf("%s: got explicit hash key %q, want %q", tc.strategy, hashKey, tc.hashKey)
+		}
+	}
+}
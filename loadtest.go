@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
+)
+
+// loadTest sends fresh MetadataCreate messages at a steady rate, e.g. to size
+// the Kinesis consumer of the channel adapter.
+type loadTest struct {
+	Rate        float64 // Messages per second.
+	Duration    time.Duration
+	Concurrency int
+}
+
+// loadTestReport summarizes a load test.
+type loadTestReport struct {
+	Sent      int
+	Failed    int
+	Skipped   int // Ticks missed because all the senders were busy.
+	Elapsed   time.Duration
+	Latencies []time.Duration // Sorted, successful sends only.
+}
+
+// newLoadTestMessage returns a MetadataCreate message with a new message ID
+// and a new object UUID. It is compact like the messages sent from the UI, so
+// the load test measures records of the same size.
+func newLoadTestMessage() (string, error) {
+	m := createMessage()
+	mcr, err := m.MetadataCreateRequest()
+	if err != nil {
+		return "", err
+	}
+	mcr.ObjectUuid = message.NewUUID()
+	msg, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(msg), nil
+}
+
+// interval returns the time between two messages, or an error if the rate
+// doesn't give an interval of at least a nanosecond that time.Duration can
+// hold, e.g. it is not positive or too large.
+func (t loadTest) interval() (time.Duration, error) {
+	interval := float64(time.Second) / t.Rate
+	if !(interval >= 1 && interval < math.MaxInt64) {
+		return 0, fmt.Errorf("the load test rate must be between %.3g and %.3g messages per second", float64(time.Second)/math.MaxInt64, float64(time.Second))
+	}
+	return time.Duration(interval), nil
+}
+
+// check reports whether the load test can be run.
+func (t loadTest) check() error {
+	if t.Concurrency < 1 {
+		return fmt.Errorf("the load test requires at least one sender")
+	}
+	_, err := t.interval()
+	return err
+}
+
+// Run sends the messages until the duration elapses. A message is generated
+// on every tick and handed to the first sender available, the tick is skipped
+// when all of them are busy so the rate is never exceeded.
+func (t loadTest) Run() loadTestReport {
+	var (
+		report = loadTestReport{Latencies: []time.Duration{}}
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		queue  = make(chan string)
+	)
+	for i := 0; i < t.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range queue {
+				ctx, cancel := context.WithTimeout(withoutHistory(context.Background()), time.Second*4)
+				start := time.Now()
+				_, err := sendMessage(ctx, msg)
+				latency := time.Since(start)
+				cancel()
+				mu.Lock()
+				if err != nil {
+					report.Failed++
+					log.Printf("[ERROR] The message could not be sent: %s", err)
+				} else {
+					report.Sent++
+					report.Latencies = append(report.Latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	interval, _ := t.interval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	start := time.Now()
+	deadline := time.After(t.Duration)
+loop:
+	for {
+		select {
+		case <-deadline:
+			break loop
+		case <-ticker.C:
+			msg, err := newLoadTestMessage()
+			if err != nil {
+				log.Printf("[ERROR] The message could not be generated: %s", err)
+				continue
+			}
+			select {
+			case queue <- msg:
+			default:
+				mu.Lock()
+				report.Skipped++
+				mu.Unlock()
+			}
+		}
+	}
+	close(queue)
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+
+	sort.Slice(report.Latencies, func(i, j int) bool { return report.Latencies[i] < report.Latencies[j] })
+	return report
+}
+
+// Percentile returns the latency below which the given percentage of the
+// successful sends fall, using the nearest-rank method.
+func (r loadTestReport) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	// p/100 is inexact, e.g. the 7th percentile of 100 sends would be the
+	// 8th one.
+	rank := int(math.Ceil(p * float64(len(r.Latencies)) / 100))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(r.Latencies) {
+		rank = len(r.Latencies)
+	}
+	return r.Latencies[rank-1]
+}
+
+// Throughput returns the number of messages sent per second.
+func (r loadTestReport) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Sent) / r.Elapsed.Seconds()
+}
+
+func (r loadTestReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "Elapsed:    %s\n", r.Elapsed)
+	fmt.Fprintf(w, "Sent:       %d\n", r.Sent)
+	fmt.Fprintf(w, "Failed:     %d\n", r.Failed)
+	fmt.Fprintf(w, "Skipped:    %d\n", r.Skipped)
+	fmt.Fprintf(w, "Throughput: %.2f msg/s\n", r.Throughput())
+	if len(r.Latencies) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Latency:    min=%s p50=%s p90=%s p95=%s p99=%s max=%s\n",
+		r.Latencies[0], r.Percentile(50), r.Percentile(90), r.Percentile(95), r.Percentile(99), r.Latencies[len(r.Latencies)-1])
+}
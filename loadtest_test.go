@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	ms := func(values ...int) []time.Duration {
+		latencies := make([]time.Duration, len(values))
+		for i, v := range values {
+			latencies[i] = time.Duration(v) * time.Millisecond
+		}
+		return latencies
+	}
+	tests := []struct {
+		name      string
+		latencies []time.Duration
+		p         float64
+		want      time.Duration
+	}{
+		{"no sends", nil, 50, 0},
+		{"n=1 p=0", ms(7), 0, 7 * time.Millisecond},
+		{"n=1 p=50", ms(7), 50, 7 * time.Millisecond},
+		{"n=1 p=100", ms(7), 100, 7 * time.Millisecond},
+		{"n=4 p=25", ms(1, 2, 3, 4), 25, 1 * time.Millisecond},
+		{"n=4 p=50", ms(1, 2, 3, 4), 50, 2 * time.Millisecond},
+		{"n=4 p=51", ms(1, 2, 3, 4), 51, 3 * time.Millisecond},
+		{"n=4 p=100", ms(1, 2, 3, 4), 100, 4 * time.Millisecond},
+		{"n=10 p=90", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 90, 9 * time.Millisecond},
+		{"n=10 p=70", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 70, 7 * time.Millisecond},
+		{"n=10 p=99", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 99, 10 * time.Millisecond},
+		{"n=3 p=100", ms(1, 2, 3), 100, 3 * time.Millisecond},
+		{"n=3 p=101", ms(1, 2, 3), 101, 3 * time.Millisecond},
+		{"n=100 p=7", ms(sequence(100)...), 7, 7 * time.Millisecond},
+		{"n=600 p=7", ms(sequence(600)...), 7, 42 * time.Millisecond},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := loadTestReport{Latencies: tc.latencies}
+			if got := r.Percentile(tc.p); got != tc.want {
+				t.Errorf("Percentile(%v) = %s, want %s", tc.p, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadTestCheck(t *testing.T) {
+	tests := []struct {
+		rate        float64
+		concurrency int
+		interval    time.Duration // Zero if the check fails.
+	}{
+		{10, 4, 100 * time.Millisecond},
+		{0.5, 1, 2 * time.Second},
+		{1e9, 1, time.Nanosecond},
+		{1e9 + 1, 1, 0}, // The interval rounds to zero, the ticker would panic.
+		{2e9, 1, 0},
+		{1e-10, 1, 0}, // The interval overflows time.Duration.
+		{0, 1, 0},
+		{-1, 1, 0},
+		{math.Inf(1), 1, 0},
+		{math.NaN(), 1, 0},
+		{10, 0, 0},
+	}
+	for _, tc := range tests {
+		lt := loadTest{Rate: tc.rate, Concurrency: tc.concurrency}
+		err := lt.check()
+		if tc.interval == 0 {
+			if err == nil {
+				t.Errorf("rate=%v concurrency=%d: the load test was accepted", tc.rate, tc.concurrency)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("rate=%v concurrency=%d: unexpected error: %s", tc.rate, tc.concurrency, err)
+			continue
+		}
+		if interval, _ := lt.interval(); interval != tc.interval {
+			t.Errorf("rate=%v: got interval %s, want %s", tc.rate, interval, tc.interval)
+		}
+	}
+}
+
+func TestLoadTestMessageIsCompact(t *testing.T) {
+	msg, err := newLoadTestMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != len(msg) {
+		t.Errorf("the message is %d bytes, %d once compacted", len(msg), buf.Len())
+	}
+}
+
+// sequence returns the integers from 1 to n.
+func sequence(n int) []int {
+	values := make([]int, n)
+	for i := range values {
+		values[i] = i + 1
+	}
+	return values
+}
@@ -9,8 +9,9 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
@@ -23,7 +24,10 @@ import (
 	"github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
 )
 
-const html = `<!DOCTYPE html>
+// layout is shared by all the pages, e.g.:
+//
+//	{{template "header" .}} ... {{template "footer" .}}
+const layout = `{{define "header"}}<!DOCTYPE html>
 <html>
 	<head>
 		<meta charset="utf-8">
@@ -51,10 +55,43 @@ const html = `<!DOCTYPE html>
 				padding: 8px;
 				margin-bottom: 10px;
 			}
+			.types .button {
+				margin-right: 5px;
+			}
+			.target {
+				background-color: #9b4dca;
+				color: #fff;
+				padding: 8px;
+				margin-bottom: 20px;
+			}
 		</style>
 	</head>
 	<body>
-		<h1><a href="{{.Prefix}}">RDSS Archivematica Msgcreator</a></h1>
+		<h1><a href="{{url "/"}}">RDSS Archivematica Msgcreator</a></h1>
+		<p class="nav">
+			<a href="{{url "/"}}">Compose</a> &middot;
+			<a href="{{url "/batch"}}">Batch</a> &middot;
+			<a href="{{url "/replay"}}">Replay</a> &middot;
+			<a href="{{url "/streams"}}">Streams</a> &middot;
+			<a href="{{url "/sent"}}">Sent</a> &middot;
+			<a href="{{url "/history"}}">History</a> &middot;
+			<a href="{{url "/buckets"}}">Buckets</a> &middot;
+			<a href="{{url "/browse/"}}">Browse</a> &middot;
+			<a href="{{url "/checksums"}}">Checksum cache</a>
+		</p>
+		{{with activeTarget}}{{if .Name}}
+			<div class="target"{{if .Color}} style="background-color: {{.Color}}"{{end}}>
+				Target: <strong>{{.Name}}</strong>{{if .Description}} &mdash; {{.Description}}{{end}}
+				<br /><small>Kinesis: {{if .KinesisEndpoint}}{{.KinesisEndpoint}}{{else}}AWS{{if .KinesisRegion}} ({{.KinesisRegion}}){{end}}{{end}}, stream {{.KinesisStream}} &middot; S3: {{if .S3Endpoint}}{{.S3Endpoint}}{{else}}AWS{{if .S3Region}} ({{.S3Region}}){{end}}{{end}}, bucket {{.S3DefaultBucket}}</small>
+			</div>
+		{{end}}{{end}}
+{{end}}
+{{define "footer"}}
+	</body>
+</html>
+{{end}}`
+
+const html = `{{template "header" .}}
 		{{if .Post}}
 			<h3>We're trying to send your message...</h3>
 			{{if .Result}}
@@ -62,44 +99,175 @@ const html = `<!DOCTYPE html>
 					{{.Result}}
 					{{if .ShardID}}<br />ShardId: {{.ShardID}}{{end}}
 					{{if .SequenceNumber}}<br />SequenceNumber: {{.SequenceNumber}}{{end}}
+					{{if .PartitionKey}}<br />PartitionKey: {{.PartitionKey}}{{end}}
+					{{if .ExplicitHashKey}}<br />ExplicitHashKey: {{.ExplicitHashKey}}{{end}}
+					{{if .Destination}}<br />{{.Destination}}{{end}}
+					{{if .DryRun}}<br />Stream: {{if .Stream}}{{.Stream}}{{else}}n/a{{end}}<br />Record size: {{.RecordSize}} bytes{{end}}
+					{{if and .Watching .MessageID}}<br /><a href="{{url "/sent/"}}{{.MessageID}}">Follow the message</a> to find out whether the channel adapter rejects it.{{end}}
+					{{if and .Stream .SequenceNumber}}<br /><a href="{{url "/streams"}}?stream={{.Stream}}&amp;shard={{.ShardID}}&amp;type=AT_SEQUENCE_NUMBER&amp;sequence={{.SequenceNumber}}&amp;limit=1">View the record in the stream</a>{{end}}
+				</div>
+			{{end}}
+			{{if .Parts}}
+				<table>
+					<thead>
+						<tr><th>Part</th><th>messageId</th><th>Files</th><th>Size</th><th>{{if .DryRun}}PartitionKey{{else}}ShardId / SequenceNumber{{end}}</th></tr>
+					</thead>
+					<tbody>
+						{{range .Parts}}
+							<tr>
+								<td>{{.Position}} of {{.Total}}</td>
+								<td><small>{{if and $.Watching (not .Error) (not $.DryRun)}}<a href="{{url "/sent/"}}{{.MessageID}}">{{.MessageID}}</a>{{else}}{{.MessageID}}{{end}}</small></td>
+								<td>{{.Files}}</td>
+								<td>{{.Size}} bytes</td>
+								<td>{{if .Error}}{{.Error}}{{else if $.DryRun}}{{.PartitionKey}}{{else}}{{.ShardID}} / {{.SequenceNumber}}{{end}}</td>
+							</tr>
+						{{end}}
+					</tbody>
+				</table>
+			{{else if .DryRun}}
+				<p>Exact bytes of the record:</p>
+				<pre><code>{{.Record}}</code></pre>
+			{{end}}
+			{{if .ValidationIssues}}
+				<div class="error">
+					<p>The validator found {{len .ValidationIssues}} issue(s) in the message:</p>
+					<ul>
+						{{range .ValidationIssues}}<li><code>{{.Field}}</code>: {{.Description}}</li>{{end}}
+					</ul>
 				</div>
 			{{end}}
 			<hr />
-			<a href="/">Send a new message</a>
+			<a href="{{url "/"}}">Send a new message</a>
 		{{else}}
-			<h3>Compose a message and send it to Kinesis.</h3>
-			{{if .S3Available}}
-				<p>The document below is a <code>MetadataCreate</code> message populated with files found in the <code>{{.Bucket}}</code> sample bucket. Only up to {{.MaxKeys}} files are being listed. Checksums are only calculated if you include the command-line argument <code>-checksums</code>.</p>
-				<p>You can choose a different bucket passing it in the URL, e.g. <code>/with-files/{{.Bucket}}</code>. You can add an extra prefix to filter the results, e.g.: <code>/with-files/{{.Bucket}}/wood</code>.</p>
+			<h3>Compose a message and send it ({{.Publisher}}).</h3>
+			{{if gt (len targets) 1}}
+				<form method="POST" action="{{url "/target"}}" class="row">
+					<div class="column column-50">
+						<select name="target">
+							{{range targets}}<option value="{{.Name}}"{{if eq .Name activeTarget.Name}} selected{{end}}>{{.Name}}{{if .Description}} ({{.Description}}){{end}}</option>{{end}}
+						</select>
+					</div>
+					<div class="column"><button type="submit" class="button button-outline">Switch target</button></div>
+				</form>
+			{{end}}
+			<p class="types">
+				{{range .MessageTypes}}
+					<a href="{{url "/compose/"}}{{.}}" class="button{{if ne . $.MessageType}} button-outline{{end}}">{{.}}</a>
+				{{end}}
+			</p>
+			{{if .ValidationIssues}}
+				<div class="error">
+					<p>The message was not sent because the validator found {{len .ValidationIssues}} issue(s):</p>
+					<ul>
+						{{range .ValidationIssues}}<li><code>{{.Field}}</code>: {{.Description}}</li>{{end}}
+					</ul>
+					<p>Use <code>-validation=warnings</code> if you want to send invalid messages.</p>
+				</div>
+			{{else if .Draft}}
+				<p>The document below is the {{.Draft.Kind}} <code>{{.Draft.Name}}</code>, saved at {{.Draft.Updated.Format "2006-01-02 15:04:05"}}{{if .Draft.Path}} in <code>{{.Draft.Path}}</code>{{end}}.</p>
+			{{else if not .WithFiles}}
+				<p>The document below is a <code>{{.MessageType}}</code> message populated with a default body.</p>
+			{{else if and .S3Available .Picked}}
+				<p>The document below is a <code>{{.MessageType}}</code> message populated with the files picked from <code>s3://{{.Bucket}}/{{.Prefix}}</code>. <a href="{{url "/browse/"}}{{.Bucket}}/{{.Prefix}}">Pick the files again</a>.</p>
+			{{else if .S3Available}}
+				<p>The document below is a <code>{{.MessageType}}</code> message populated with files found in the <code>{{.Bucket}}</code> sample bucket. {{if .All}}All the files under the prefix are being listed, up to {{.MaxObjects}}.{{else}}Only up to {{.MaxKeys}} files are being listed per page.{{end}} Checksums are only calculated if you include the command-line argument <code>-checksums</code>, see also <code>-checksum-algorithms</code>.</p>
+				<p><a href="{{url "/browse/"}}{{.Bucket}}/{{.Prefix}}">Pick the files</a> that you want to include in the message instead.</p>
+				<p class="pager">
+					{{if or .Token .All}}<a href="{{url "/with-files/"}}{{.Bucket}}/{{.Prefix}}" class="button button-outline">First page</a>{{end}}
+					{{if .NextToken}}<a href="{{url "/with-files/"}}{{.Bucket}}/{{.Prefix}}?token={{.NextToken}}{{if .All}}&amp;all=true{{end}}" class="button button-outline">{{if .All}}More objects{{else}}Next page{{end}}</a>{{end}}
+					{{if not .All}}<a href="{{url "/with-files/"}}{{.Bucket}}/{{.Prefix}}?all=true" class="button button-outline">Include all objects</a>{{end}}
+				</p>
+				<p>You can choose a different bucket passing it in the URL, e.g. <code>/with-files/{{.Bucket}}</code>. You can add an extra prefix to filter the results, e.g.: <code>/with-files/{{.Bucket}}/wood</code>. Or <a href="{{url "/folders/"}}{{.Bucket}}/{{.Prefix}}">navigate the folders</a> of the bucket.</p>
 			{{else}}
 				<div class="error">
 					<p>An error occurred trying to access S3! See the logs for more details.<br />As a result, the message generated below will not include any files.</p>
 				</div>
 			{{end}}
-			<form method="POST">
+			<form method="POST" action="{{url "/"}}">
 				<textarea name="message">{{.DefaultMessage}}</textarea>
+				<p><input type="checkbox" name="dry-run" id="dry-run" value="true"{{if .DryRun}} checked{{end}} /><label class="label-inline" for="dry-run">Dry run, show what would be published without sending it</label></p>
+				<p><input type="checkbox" name="split" id="split" value="true"{{if .Split}} checked{{end}} /><label class="label-inline" for="split">Split the files across several messages when the message doesn't fit in a Kinesis record</label></p>
 				<button type="submit" class="button">Send</a>
+				<div class="row">
+					<div class="column column-50"><input type="text" name="name" value="{{with .Draft}}{{.Name}}{{end}}" placeholder="Name, e.g. create-with-many-files" /></div>
+					<div class="column">
+						<select name="kind">
+							<option value="draft">Draft</option>
+							<option value="template"{{if and .Draft (eq .Draft.Kind "template")}} selected{{end}}>Shared template</option>
+						</select>
+					</div>
+					<div class="column"><button type="submit" formaction="{{url "/drafts"}}" class="button button-outline">Save</button></div>
+				</div>
 			</form>
+			{{if .Drafts}}
+				<h4>Drafts and templates</h4>
+				<table>
+					<thead>
+						<tr><th>Name</th><th>Kind</th><th>Message</th><th>Updated</th><th></th></tr>
+					</thead>
+					<tbody>
+						{{range .Drafts}}
+							<tr>
+								<td><a href="{{url "/drafts/"}}{{.Kind}}/{{.Name}}">{{.Name}}</a></td>
+								<td>{{.Kind}}{{if .Path}}<br /><small>{{.Path}}</small>{{end}}</td>
+								<td><code>{{.MessageType}}</code></td>
+								<td>{{.Updated.Format "2006-01-02 15:04:05"}}</td>
+								<td>
+									{{if not .Path}}
+										<form method="POST" action="{{url "/drafts"}}">
+											<input type="hidden" name="kind" value="{{.Kind}}" />
+											<input type="hidden" name="name" value="{{.Name}}" />
+											<button type="submit" name="delete" value="true" class="button button-outline">Delete</button>
+										</form>
+									{{end}}
+								</td>
+							</tr>
+						{{end}}
+					</tbody>
+				</table>
+			{{end}}
 		{{end}}
-	</body>
-</html>
-`
+{{template "footer" .}}`
 
 type Page struct {
-	Post           bool
-	Prefix         string
-	DefaultMessage string
-	Bucket         string
-	Result         string
-	ShardID        string
-	SequenceNumber string
-	S3Available    bool
-	MaxKeys        int64
+	Post             bool
+	Prefix           string
+	MessageType      string
+	MessageTypes     []string
+	WithFiles        bool
+	DefaultMessage   string
+	Bucket           string
+	Result           string
+	ShardID          string
+	SequenceNumber   string
+	PartitionKey     string
+	ExplicitHashKey  string
+	Stream           string
+	MessageID        string
+	Watching         bool
+	DryRun           bool
+	RecordSize       int
+	Record           string
+	Split            bool
+	Parts            []*sendResponse
+	Draft            *draft
+	Drafts           []draft
+	Destination      string
+	Publisher        string
+	S3Available      bool
+	MaxKeys          int64
+	MaxObjects       int64
+	Token            string
+	NextToken        string
+	All              bool
+	Picked           bool
+	ValidationIssues []validationIssue
 }
 
 var (
-	tmpl = template.Must(template.New("index").Parse(html))
-	re   = regexp.MustCompile("^/with-files/(.*)")
+	tmpl      = template.Must(template.Must(template.New("index").Funcs(layoutFuncs).Parse(html)).Parse(layout))
+	re        = regexp.MustCompile("^/with-files/(.*)")
+	composeRe = regexp.MustCompile("^/compose/([A-Za-z]+)$")
 )
 
 func handler(w http.ResponseWriter, r *http.Request) {
@@ -108,7 +276,10 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	values := re.FindStringSubmatch(r.URL.Path)
 	withFiles := len(values) > 1
 
-	if r.URL.Path != "/" && !withFiles {
+	composeValues := composeRe.FindStringSubmatch(r.URL.Path)
+	compose := len(composeValues) > 1
+
+	if r.URL.Path != "/" && !withFiles && !compose {
 		http.Error(w, "", http.StatusNotFound)
 		return
 	}
@@ -119,10 +290,12 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodGet {
-		if withFiles {
+		if compose {
+			renderComposedForm(w, r, composeValues[1])
+		} else if withFiles {
 			renderFormWithFiles(w, r, values[1])
 		} else {
-			renderFormWithFiles(w, r, *s3DefaultBucket)
+			renderFormWithFiles(w, r, activeTarget().S3DefaultBucket)
 		}
 		return
 	}
@@ -130,181 +303,426 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "I don't know what you're trying to do!", http.StatusNotFound)
 }
 
-func renderFormWithFiles(w http.ResponseWriter, r *http.Request, query string) {
-	parts := strings.Split(query, "/")
-
-	var bucket, keyPrefix string
-	if n := len(parts); n < 1 {
-		http.Error(w, "", http.StatusNotFound)
-		return
-	} else if n == 1 {
-		bucket = parts[0]
-	} else if n > 1 {
-		bucket = parts[0]
-		keyPrefix = strings.Join(parts[1:], "/")
+// splitBucketPrefix splits a query like "bucket/some/prefix" into the name of
+// the bucket and the key prefix.
+func splitBucketPrefix(query string) (bucket, keyPrefix string) {
+	parts := strings.SplitN(query, "/", 2)
+	bucket = parts[0]
+	if len(parts) > 1 {
+		keyPrefix = parts[1]
 	}
+	return bucket, keyPrefix
+}
 
-	log.Printf("Accessing to S3, bucket=%s prefix=%s keys=%v", bucket, keyPrefix, *s3MaxKeys)
-	req := &s3.ListObjectsV2Input{
-		Bucket:  aws.String(bucket),
-		MaxKeys: s3MaxKeys,
-		Prefix:  aws.String(keyPrefix),
+func renderFormWithFiles(w http.ResponseWriter, r *http.Request, query string) {
+	bucket, keyPrefix := splitBucketPrefix(query)
+
+	params := r.URL.Query()
+	req := generateRequest{
+		Type:      message.MessageTypeMetadataCreate.String(),
+		Bucket:    bucket,
+		Prefix:    keyPrefix,
+		Token:     params.Get("token"),
+		All:       params.Get("all") == "true",
+		Checksums: *checksums,
 	}
-	resp, err := s3Client.ListObjectsV2(req)
+
+	objects, nextToken, err := req.listObjects()
 	var s3Available = true
 	if err != nil {
 		s3Available = false
 		log.Printf("[ERROR] S3 not available! bucket=%s prefix=%s - %s", bucket, keyPrefix, err)
 	}
 
-	m := createMessage()
-	mcr, err := m.MetadataCreateRequest()
+	renderFormWithObjects(w, r, req, objects, &Page{
+		Prefix:      keyPrefix,
+		Bucket:      bucket,
+		S3Available: s3Available,
+		Token:       req.Token,
+		NextToken:   nextToken,
+		All:         req.All,
+	})
+}
+
+// renderFormWithObjects renders the form with the MetadataCreate message
+// requested populated with the objects given.
+func renderFormWithObjects(w http.ResponseWriter, r *http.Request, req generateRequest, objects []*s3.Object, p *Page) {
+	p.MessageType = req.Type
+	p.WithFiles = true
+
+	if !p.S3Available {
+		// The message keeps the files of the default body.
+		req.Bucket = ""
+	} else if req.Checksums && len(objects) > 0 {
+		// Computing checksums is slow, it is done in the background and the
+		// user is redirected to the page that reports the progress.
+		j := startFilesJob(req, objects, p)
+		redirect(w, r, "/jobs/"+j.ID, http.StatusSeeOther)
+		return
+	}
+
+	m, err := composeRequested(req, objects, nil)
 	if err != nil {
 		http.Error(w, "Unexpected error creating message", http.StatusInternalServerError)
 		return
 	}
+	msg, err := encodeMessage(m)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding JSON: %s", err), http.StatusInternalServerError)
+		return
+	}
+	p.DefaultMessage = string(msg)
+	renderForm(w, r, p)
+}
 
-	if s3Available {
-		mcr.ObjectFile = []message.File{}
-		for _, object := range resp.Contents {
-			var checksum string
-			if *checksums {
-				checksum = hasher(s3Client, bucket, *object.Key, *object.ETag)
-			}
-			file := createFile(
-				fmt.Sprintf("%s", message.NewUUID()),
-				fmt.Sprintf("s3://%s/%s", bucket, *object.Key),
-				*object.Key,
-				checksum,
-			)
-			mcr.ObjectFile = append(mcr.ObjectFile, *file)
-		}
+// renderComposedForm renders the form with the default message of the given
+// type. MetadataCreate messages are populated with the files found in the
+// default bucket.
+func renderComposedForm(w http.ResponseWriter, r *http.Request, messageType string) {
+	if messageType == message.MessageTypeMetadataCreate.String() {
+		renderFormWithFiles(w, r, activeTarget().S3DefaultBucket)
+		return
 	}
 
-	msg, err := encodeMessage(m)
+	resp, err := generateMessage(generateRequest{Type: messageType})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error encoding JSON: %s", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	renderForm(w, r, s3Available, string(msg), keyPrefix, bucket)
+	renderForm(w, r, &Page{
+		MessageType:    messageType,
+		DefaultMessage: string(resp.Message),
+	})
 }
 
 func submitForm(w http.ResponseWriter, r *http.Request) {
 	p := &Page{Post: true}
 	if err := r.ParseForm(); err != nil {
 		p.Result = fmt.Sprintf("The form could not be parsed: %s", err)
-		renderTemplate(w, p)
+		renderTemplate(w, r, p)
 		return
 	}
 
 	msg := r.PostFormValue("message")
 	if msg == "" {
 		p.Result = "The message is empty, try again!"
-		renderTemplate(w, p)
+		renderTemplate(w, r, p)
 		return
 	}
 
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, time.Second*4)
-	defer cancel()
+	submitMessage(w, r, msg, r.PostFormValue("dry-run") != "", r.PostFormValue("split") != "")
+}
+
+// submitMessage sends a message with the API, e.g. the message of the compose
+// form or a message resent from the history, and renders the result.
+func submitMessage(w http.ResponseWriter, r *http.Request, msg string, dryRun, split bool) {
+	resp, err := publishMessage(context.Background(), []byte(msg), dryRun, split)
+	if e, ok := err.(*apiError); ok && len(e.Issues) > 0 {
+		renderForm(w, r, &Page{
+			MessageType:      messageType([]byte(msg)),
+			DefaultMessage:   msg,
+			ValidationIssues: e.Issues,
+			DryRun:           dryRun,
+			Split:            split,
+		})
+		return
+	}
 
-	p.DefaultMessage = msg
-	shardID, sequenceNumber, err := sendMessage(ctx, msg)
+	p := &Page{Post: true, DefaultMessage: msg}
 	if err != nil {
 		p.Result = fmt.Sprintf("The message could not be sent: %s", err)
-	} else {
+		renderTemplate(w, r, p)
+		return
+	}
+
+	p.ValidationIssues = resp.Issues
+	p.PartitionKey = resp.PartitionKey
+	p.ExplicitHashKey = resp.ExplicitHashKey
+	p.Stream = resp.Stream
+	p.Destination = resp.Detail
+	p.DryRun = resp.DryRun
+	p.Watching = watching
+	switch {
+	case resp.Parts != nil:
+		p.Parts = resp.Parts
+		switch {
+		case dryRun:
+			p.Result = fmt.Sprintf("Dry run, the message was split in %d parts but they were not sent.", resp.Total)
+		case resp.Error != "":
+			p.Result = fmt.Sprintf("The message was split in %d parts, %s.", resp.Total, resp.Error)
+		default:
+			p.Result = fmt.Sprintf("The message was split in %d parts, all of them were sent!", resp.Total)
+		}
+	case resp.DryRun:
+		p.Result = "Dry run, the message was not sent."
+		p.RecordSize = resp.Size
+		p.Record = resp.Record
+	default:
 		p.Result = "Message sent!"
-		p.ShardID = shardID
-		p.SequenceNumber = sequenceNumber
+		p.ShardID = resp.ShardID
+		p.SequenceNumber = resp.SequenceNumber
+		p.MessageID = resp.MessageID
 	}
 
-	renderTemplate(w, p)
+	renderTemplate(w, r, p)
 }
 
-func renderForm(w http.ResponseWriter, r *http.Request, s3Available bool, message, prefix, bucket string) {
-	p := &Page{
-		Prefix:         prefix,
-		DefaultMessage: message,
-		Bucket:         bucket,
-		S3Available:    s3Available,
-		MaxKeys:        *s3MaxKeys,
-	}
+func renderForm(w http.ResponseWriter, r *http.Request, p *Page) {
+	p.MessageTypes = messageTypes()
+	p.MaxKeys = *s3MaxKeys
+	p.MaxObjects = *s3MaxObjects
+	p.Publisher = *publisherName
+	p.DryRun = p.DryRun || *dryRun
+	p.Split = p.Split || *splitMessages
+	p.Drafts = drafts.List()
 
-	renderTemplate(w, p)
+	renderTemplate(w, r, p)
 }
 
-func renderTemplate(w http.ResponseWriter, p *Page) {
-	err := tmpl.Execute(w, p)
+func renderTemplate(w http.ResponseWriter, r *http.Request, p *Page) {
+	err := executeTemplate(w, r, tmpl, p)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-func sendMessage(ctx context.Context, msg string) (string, string, error) {
+func sendMessage(ctx context.Context, msg string) (*receipt, error) {
 	blob := []byte(msg)
 
 	err := json.Unmarshal(blob, &struct{}{})
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	req := &kinesis.PutRecordInput{
-		Data:         blob,
-		StreamName:   kinesisStream,
-		PartitionKey: aws.String(strconv.FormatInt(time.Now().Unix(), 10)),
+	if isDryRun(ctx) {
+		return previewMessage(ctx, blob)
 	}
-	resp, err := kinesisClient.PutRecordWithContext(ctx, req)
+
+	rcpt, err := activeConnection().publisher.Publish(ctx, blob)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-
-	return *resp.ShardId, *resp.SequenceNumber, err
+	recordSent(ctx, blob, rcpt)
+	return rcpt, nil
 }
 
 var (
-	kinesisClient   *kinesis.Kinesis
-	kinesisStream   *string
-	s3Client        *s3.S3
+	publisherName *string
+	publisherOpts *string
+	kinesisStream *string
+
+	kinesisStreamInvalid *string
+	kinesisStreamError   *string
+
+	kinesisPartitionKey      *string
+	kinesisPartitionKeyValue *string
+	kinesisMaxRetries        *int
+
 	s3DefaultBucket *string
 	s3MaxKeys       *int64
+	s3MaxObjects    *int64
+	s3CreatedMeta   *bool
 	prefix          *string
 	checksums       *bool
+	checksumTypes   []message.ChecksumTypeEnum
+	validation      validationMode
+	watchGrace      *time.Duration
+	watching        bool
+	dryRun          *bool
+	splitMessages   *bool
+
+	addr                *string
+	generateType        *string
+	generateAll         *bool
+	replayPath          *string
+	replayIDs           *string
+	replaySpeed         *string
+	replayTypes         *string
+	loadTestRate        *float64
+	loadTestDuration    *time.Duration
+	loadTestConcurrency *int
 )
 
 func main() {
 	var (
-		addr            = flag.String("addr", "0.0.0.0:8000", "listen address")
-		kinesisRegion   = flag.String("kinesis-region", "", "Kinesis - Region")
-		kinesisEndpoint = flag.String("kinesis-endpoint", "", "Kinesis - Endpoint")
-		s3AccessKey     = flag.String("s3-access-key", "", "S3 - Access key")
-		s3SecretKey     = flag.String("s3-secret-key", "", "S3 - Secret key")
-		s3Region        = flag.String("s3-region", "", "S3 - Region")
-		s3Endpoint      = flag.String("s3-endpoint", "", "S3 - Endpoint")
+		kinesisAccessKey    = flag.String("kinesis-access-key", "", "Kinesis - Access key, the default credential chain of the AWS SDK is used when empty")
+		kinesisSecretKey    = flag.String("kinesis-secret-key", "", "Kinesis - Secret key")
+		kinesisSessionToken = flag.String("kinesis-session-token", "", "Kinesis - Session token")
+		kinesisProfile      = flag.String("kinesis-profile", "", "Kinesis - Shared credentials profile, e.g. `default`, AWS_PROFILE is used when empty")
+		kinesisRegion       = flag.String("kinesis-region", "", "Kinesis - Region")
+		kinesisEndpoint     = flag.String("kinesis-endpoint", "", "Kinesis - Endpoint")
+		s3AccessKey         = flag.String("s3-access-key", "", "S3 - Access key")
+		s3SecretKey         = flag.String("s3-secret-key", "", "S3 - Secret key")
+		s3Region            = flag.String("s3-region", "", "S3 - Region")
+		s3Endpoint          = flag.String("s3-endpoint", "", "S3 - Endpoint")
 	)
+	addr = flag.String("addr", "0.0.0.0:8000", "listen address")
 	prefix = flag.String("prefix", "/", "Path prefix, e.g.: `/msgcreator`, similar to `--prefix` in Jenkins")
 	kinesisStream = flag.String("kinesis-stream", "main", "Kinesis - Stream")
+	kinesisStreamInvalid = flag.String("kinesis-stream-invalid", "invalid", "Kinesis - Stream where the channel adapter routes the invalid messages")
+	kinesisStreamError = flag.String("kinesis-stream-error", "error", "Kinesis - Stream where the channel adapter routes the messages that it could not process")
+	kinesisPartitionKey = flag.String("kinesis-partition-key", "timestamp", "Kinesis - Partition key strategy: `timestamp`, `message-id`, `object-uuid`, `fixed`, `random` or `hash-key`")
+	kinesisPartitionKeyValue = flag.String("kinesis-partition-key-value", "", "Kinesis - Partition key used by the `fixed` strategy or explicit hash key used by the `hash-key` strategy")
+	kinesisMaxRetries = flag.Int("kinesis-max-retries", 3, "Kinesis - Number of times the records that failed in a batch are retried")
 	s3DefaultBucket = flag.String("s3-default-bucket", "rdss-prod-figshare-0132", "S3 - default bucket")
-	s3MaxKeys = flag.Int64("s3-max-keys", 20, "S3 - Max keys listed - too many can be slow because we're fetching checksums")
+	s3MaxKeys = flag.Int64("s3-max-keys", 20, "S3 - Max keys listed per page")
+	s3MaxObjects = flag.Int64("s3-max-objects", 1000, "S3 - Max keys listed when all the objects under a prefix are included")
+	s3CreatedMeta = flag.Bool("s3-created-metadata", false, "S3 - Take the creation date of the files from the x-amz-meta-created metadata entry, it costs a HEAD request per object")
 	checksums = flag.Bool("checksums", false, "S3 - calculate checksums")
-	flag.Parse()
+	publisherName = flag.String("publisher", "kinesis", "Publisher used to send the messages: `kinesis`, `stdout`, `file` or `webhook`")
+	publisherOpts = flag.String("publisher-opts", "", "Publisher options, e.g. `path=/tmp/messages.jsonl` (file), `url=http://...` (webhook) or `stream=main` (kinesis)")
+	workers := flag.Int("workers", 4, "S3 - number of objects processed concurrently, e.g. when computing checksums")
+	checksumCachePath := flag.String("checksum-cache", filepath.Join(os.TempDir(), "rdss-archivematica-msgcreator", "checksums.json"), "S3 - checksum cache file, use an empty value to keep the cache in memory")
+	checksumAlgorithmsFlag := flag.String("checksum-algorithms", "md5", "S3 - checksum algorithms, comma-separated list of `md5` and `sha256`")
+	checksumIdleTimeout := flag.Duration("checksum-idle-timeout", 30*time.Second, "S3 - the download of an object is abandoned when no data is received for this long, large objects can take as long as needed otherwise")
+	historyPath := flag.String("history", dataPath("history.jsonl"), "History of the messages sent, use an empty value to keep it in memory")
+	historyMax := flag.Int("history-max-entries", 10000, "Number of messages kept in the history, the oldest are removed first, 0 keeps all of them")
+	draftsPath := flag.String("drafts", dataPath("drafts.json"), "Drafts and templates saved from the compose form, use an empty value to keep them in memory")
+	templatesDir := flag.String("templates", "", "Directory of shared templates loaded at startup, one message per `*.json` file")
+	validationFlag := flag.String("validation", defaultValidationMode.String(), "Message validation mode: `strict`, `warnings` or `disabled`")
+	generateType = flag.String("generate-type", "MetadataCreate", "Generate - Message type, e.g. `MetadataRead`")
+	generateAll = flag.Bool("generate-all", false, "Generate - Include all the objects under the prefix, up to -s3-max-objects, instead of the first page")
+	replayPath = flag.String("replay", "", "Same as the replay command, publish the messages captured in a JSONL file (`-` is the standard input)")
+	replayIDs = flag.String("replay-ids", "keep", "Replay - Message IDs: `keep` or `regenerate`")
+	replaySpeed = flag.String("replay-speed", "0", "Replay - Compress the original timing, e.g. `10` is ten times faster, 0 sends the messages without waiting")
+	replayTypes = flag.String("replay-types", "", "Replay - Message types replayed, e.g. `MetadataCreate,MetadataUpdate`, all of them by default")
+	dryRun = flag.Bool("dry-run", false, "Show what would be published instead of sending the messages, the compose form can override it")
+	splitMessages = flag.Bool("split-messages", false, "Split the files of the messages that don't fit in a Kinesis record across several messages, the compose form can override it")
+	targetsPath := flag.String("targets", "", "JSON file that defines the targets selectable from the UI, the flags are used as the defaults of the targets")
+	targetName := flag.String("target", "", "Target selected at startup, the first target by default")
+	watchQueuesFlag := flag.Bool("watch-queues", true, "Watch the invalid and error queues to find out whether the channel adapter rejects the messages sent (kinesis publisher only)")
+	watchGrace = flag.Duration("watch-grace", 30*time.Second, "Time after which a message that the channel adapter didn't send back is considered accepted")
+	loadTestFlag := flag.Bool("load-test", false, "Same as the load-test command, send MetadataCreate messages at a steady rate and report the results")
+	loadTestRate = flag.Float64("load-test-rate", 10, "Load test - Messages sent per second")
+	loadTestDuration = flag.Duration("load-test-duration", time.Minute, "Load test - Duration")
+	loadTestConcurrency = flag.Int("load-test-concurrency", 4, "Load test - Number of concurrent senders")
+	flag.Usage = usage
+	name, args := parseCommand(os.Args[1:])
+
+	// -replay and -load-test predate the commands.
+	switch {
+	case name != "serve":
+	case *replayPath != "":
+		name = "replay"
+	case *loadTestFlag:
+		name = "load-test"
+	}
 
+	if !strings.HasPrefix(*prefix, "/") {
+		*prefix = "/" + *prefix
+	}
 	if !strings.HasSuffix(*prefix, "/") {
 		*prefix += "/"
 	}
 
-	kinesisClient = getKinesisClient(kinesisRegion, kinesisEndpoint)
-	s3Client = getS3Client(s3AccessKey, s3SecretKey, s3Region, s3Endpoint)
+	var err error
+	if checksumTypes, err = parseChecksumAlgorithms(*checksumAlgorithmsFlag); err != nil {
+		log.Fatal(err)
+	}
+	if checksumStore, err = newChecksumCache(*checksumCachePath); err != nil {
+		log.Fatalf("Checksum cache could not be loaded: %s", err)
+	}
+	if sendHistory, err = newHistoryStore(*historyPath, *historyMax); err != nil {
+		log.Fatalf("History could not be loaded: %s", err)
+	}
+	if drafts, err = newDraftStore(*draftsPath, *templatesDir); err != nil {
+		log.Fatalf("Drafts could not be loaded: %s", err)
+	}
+	if validation, err = parseValidationMode(*validationFlag); err != nil {
+		log.Fatal(err)
+	}
+	if err := setUpValidator(validation); err != nil {
+		log.Fatalf("JSON Schema validator could not be installed: %s", err)
+	}
+
+	defaultHasher = &streamHasher{cache: checksumStore, idleTimeout: *checksumIdleTimeout}
+	startWorkers(*workers)
+
+	watching = name == "serve" && *watchQueuesFlag && *publisherName == "kinesis"
+
+	if targets, err = loadTargets(*targetsPath, target{
+		KinesisEndpoint:      *kinesisEndpoint,
+		KinesisRegion:        *kinesisRegion,
+		KinesisStream:        *kinesisStream,
+		KinesisStreamInvalid: *kinesisStreamInvalid,
+		KinesisStreamError:   *kinesisStreamError,
+		KinesisAccessKey:     *kinesisAccessKey,
+		KinesisSecretKey:     *kinesisSecretKey,
+		KinesisSessionToken:  *kinesisSessionToken,
+		KinesisProfile:       *kinesisProfile,
+		S3Endpoint:           *s3Endpoint,
+		S3Region:             *s3Region,
+		S3AccessKey:          *s3AccessKey,
+		S3SecretKey:          *s3SecretKey,
+		S3DefaultBucket:      *s3DefaultBucket,
+	}); err != nil {
+		log.Fatalf("Targets could not be loaded: %s", err)
+	}
+	if *targetName == "" {
+		*targetName = targets[0].Name
+	}
+	if err := selectTarget(*targetName); err != nil {
+		log.Fatalf("Target could not be selected: %s", err)
+	}
 
-	log.Printf("HTTP server listening on http://%s", *addr)
+	if err := commands[name].run(args); err != nil {
+		log.Fatalf("Command %s failed: %s", name, err)
+	}
+}
+
+// runServe runs the HTTP server.
+func runServe(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	log.Printf("HTTP server listening on http://%s%s", *addr, *prefix)
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", handler)
-	http.ListenAndServe(*addr, mux)
+	mux.HandleFunc("/api/generate", apiGenerateHandler)
+	mux.HandleFunc("/api/validate", apiValidateHandler)
+	mux.HandleFunc("/api/send", apiSendHandler)
+	mux.HandleFunc("/batch", batchHandler)
+	mux.HandleFunc("/replay", replayHandler)
+	mux.HandleFunc("/streams", streamsHandler)
+	mux.HandleFunc("/sent", sentHandler)
+	mux.HandleFunc("/sent/", sentHandler)
+	mux.HandleFunc("/history", historyHandler)
+	mux.HandleFunc("/history/", historyHandler)
+	mux.HandleFunc("/drafts", draftsHandler)
+	mux.HandleFunc("/drafts/", draftsHandler)
+	mux.HandleFunc("/target", targetHandler)
+	mux.HandleFunc("/checksums", checksumsHandler)
+	mux.HandleFunc("/jobs/", jobsHandler)
+	mux.HandleFunc("/browse/", browseHandler)
+	mux.HandleFunc("/buckets", bucketsHandler)
+	mux.HandleFunc("/folders/", foldersHandler)
+	return http.ListenAndServe(*addr, withPrefix(mux))
 }
 
-func getKinesisClient(region, endpoint *string) *kinesis.Kinesis {
+// dataPath returns the path of a file kept under the home directory, so it
+// survives a reboot, or an empty path to keep the data in memory if there is
+// no home directory.
+func dataPath(name string) string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".rdss-archivematica-msgcreator", name)
+}
+
+// getKinesisClient returns the Kinesis client. Static credentials are used
+// when the access key is given, otherwise they're found by the default chain
+// of the SDK: environment variables (AWS_ACCESS_KEY_ID...), the shared
+// credentials file (see profile) and the EC2/ECS roles.
+func getKinesisClient(accessKey, secretKey, sessionToken, profile, region, endpoint *string) *kinesis.Kinesis {
 	config := aws.NewConfig()
 	config.CredentialsChainVerboseErrors = aws.Bool(true)
-	config.Credentials = credentials.NewStaticCredentials("foo", "bar", "")
+	// The SDK installs AWS_CA_BUNDLE in the transport of the client, it would
+	// change http.DefaultClient under the feet of the requests in flight.
+	config.HTTPClient = &http.Client{}
+	if *accessKey != "" {
+		config.Credentials = credentials.NewStaticCredentials(*accessKey, *secretKey, *sessionToken)
+	}
 
 	if *region != "" {
 		config.Region = region
@@ -313,7 +731,11 @@ func getKinesisClient(region, endpoint *string) *kinesis.Kinesis {
 		config.Endpoint = endpoint
 	}
 
-	sess := session.Must(session.NewSession(config))
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		Profile:           *profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}))
 	return kinesis.New(sess)
 }
 
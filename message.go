@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 
 	. "github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
@@ -11,141 +12,237 @@ func encodeMessage(msg *Message) ([]byte, error) {
 	return json.MarshalIndent(msg, "", "  ")
 }
 
-func createMessage() *Message {
-	return &Message{
-		MessageHeader: MessageHeader{
-			ID:            NewUUID(),
-			MessageClass:  MessageClassCommand,
-			MessageType:   MessageTypeMetadataCreate,
-			ReturnAddress: "string",
-			MessageTimings: MessageTimings{
-				PublishedTimestamp:  Timestamp(time.Date(2004, time.August, 1, 10, 0, 0, 0, time.UTC)),
-				ExpirationTimestamp: Timestamp(time.Date(2004, time.August, 1, 10, 0, 0, 0, time.UTC)),
-			},
-			MessageSequence: MessageSequence{
-				Sequence: NewUUID(),
-				Position: 1,
-				Total:    1,
-			},
-			MessageHistory: []MessageHistory{
-				MessageHistory{
-					MachineId:      "string",
-					MachineAddress: "machine.example.com",
-					Timestamp:      Timestamp(time.Date(2004, time.August, 1, 10, 0, 0, 0, time.UTC)),
-				},
+// defaultObjectUUID is the research object described by the default
+// MetadataCreate message. The composers of other message types refer to it so
+// they target the object that the default message would have created.
+const defaultObjectUUID = "5680e8e0-28a5-4b20-948e-fd0d08781e0b"
+
+// composer returns a message of a given type populated with a default body.
+type composer func() *Message
+
+var composers = map[MessageType]composer{
+	MessageTypeMetadataCreate:  createMessage,
+	MessageTypeMetadataRead:    createMetadataReadMessage,
+	MessageTypeMetadataUpdate:  createMetadataUpdateMessage,
+	MessageTypeMetadataDelete:  createMetadataDeleteMessage,
+	MessageTypeVocabularyRead:  createVocabularyReadMessage,
+	MessageTypeVocabularyPatch: createVocabularyPatchMessage,
+}
+
+// messageTypes returns the names of the message types that can be composed.
+func messageTypes() []string {
+	names := []string{}
+	for t := MessageTypeMetadataCreate; t <= MessageTypeVocabularyPatch; t++ {
+		if _, ok := composers[t]; ok {
+			names = append(names, t.String())
+		}
+	}
+	return names
+}
+
+// composeMessage returns a new message of the type given by its name, e.g.
+// "MetadataRead".
+func composeMessage(name string) (*Message, error) {
+	for t, fn := range composers {
+		if t.String() == name {
+			return fn(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown message type %q", name)
+}
+
+func createHeader(t MessageType) MessageHeader {
+	return MessageHeader{
+		ID:            NewUUID(),
+		MessageClass:  MessageClassCommand,
+		MessageType:   t,
+		ReturnAddress: "string",
+		MessageTimings: MessageTimings{
+			PublishedTimestamp:  Timestamp(time.Date(2004, time.August, 1, 10, 0, 0, 0, time.UTC)),
+			ExpirationTimestamp: Timestamp(time.Date(2004, time.August, 1, 10, 0, 0, 0, time.UTC)),
+		},
+		MessageSequence: MessageSequence{
+			Sequence: NewUUID(),
+			Position: 1,
+			Total:    1,
+		},
+		MessageHistory: []MessageHistory{
+			MessageHistory{
+				MachineId:      "string",
+				MachineAddress: "machine.example.com",
+				Timestamp:      Timestamp(time.Date(2004, time.August, 1, 10, 0, 0, 0, time.UTC)),
 			},
-			Version:   Version,
-			Generator: "rdss-archivematica-msgcreator",
 		},
+		Version:   Version,
+		Generator: "rdss-archivematica-msgcreator",
+	}
+}
+
+func createMessage() *Message {
+	return &Message{
+		MessageHeader: createHeader(MessageTypeMetadataCreate),
 		MessageBody: &MetadataCreateRequest{
-			ResearchObject{
-				ObjectUuid:  MustUUID("5680e8e0-28a5-4b20-948e-fd0d08781e0b"),
-				ObjectTitle: "string",
-				ObjectPersonRole: []PersonRole{
-					PersonRole{
-						Person: Person{
-							PersonUuid: MustUUID("27811a4c-9cb5-4e6d-a069-5c19288fae58"),
-							PersonIdentifier: []PersonIdentifier{
-								PersonIdentifier{
-									PersonIdentifierValue: "string",
-									PersonIdentifierType:  PersonIdentifierTypeEnum_ORCID,
-								},
-							},
-							PersonHonorificPrefix: "string",
-							PersonGivenNames:      "string",
-							PersonFamilyNames:     "string",
-							PersonHonorificSuffix: "string",
-							PersonMail:            "person@net",
-							PersonOrganisationUnit: OrganisationUnit{
-								OrganisationUnitUuid: MustUUID("28be7f16-0e70-461f-a2db-d9d7c64a8f17"),
-								OrganisationUuidName: "string",
-								Organisation: Organisation{
-									OrganisationJiscId:  1,
-									OrganisationName:    "string",
-									OrganisationType:    OrganisationTypeEnum_charity,
-									OrganisationAddress: "string",
-								},
-							},
-						},
-						Role: PersonRoleEnum_administrator,
-					},
-				},
-				ObjectDescription: "string",
-				ObjectRights: Rights{
-					RightsStatement: []string{"string"},
-					RightsHolder:    []string{"string"},
-					Licence: []Licence{
-						Licence{
-							LicenceName:       "string",
-							LicenceIdentifier: "string",
-							LicenseStartDate:  Timestamp(time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)),
-							LicenseEndDate:    Timestamp(time.Date(2018, time.December, 31, 23, 59, 59, 0, time.UTC)),
-						},
-					},
-					Access: []Access{
-						Access{
-							AccessType:      AccessTypeEnum_open,
-							AccessStatement: "string",
-						},
-					},
-				},
-				ObjectDate: []Date{
-					Date{
-						DateValue: "2002-10-02T10:00:00-05:00",
-						DateType:  DateTypeEnum_accepted,
-					},
-				},
-				ObjectKeywords:     []string{"string"},
-				ObjectCategory:     []string{"string"},
-				ObjectResourceType: ResourceTypeEnum_artDesignItem,
-				ObjectValue:        ObjectValueEnum_normal,
-				ObjectIdentifier: []Identifier{
-					Identifier{
-						IdentifierValue: "string",
-						IdentifierType:  1,
-					},
-				},
-				ObjectRelatedIdentifier: []IdentifierRelationship{
-					IdentifierRelationship{
-						Identifier: Identifier{
-							IdentifierValue: "string",
-							IdentifierType:  IdentifierTypeEnum_ARK,
+			ResearchObject: createResearchObject(),
+		},
+	}
+}
+
+func createMetadataReadMessage() *Message {
+	return &Message{
+		MessageHeader: createHeader(MessageTypeMetadataRead),
+		MessageBody: &MetadataReadRequest{
+			ObjectUuid: MustUUID(defaultObjectUUID),
+		},
+	}
+}
+
+func createMetadataUpdateMessage() *Message {
+	ro := createResearchObject()
+	ro.ObjectTitle = "string (updated)"
+	return &Message{
+		MessageHeader: createHeader(MessageTypeMetadataUpdate),
+		MessageBody: &MetadataUpdateRequest{
+			ResearchObject: ro,
+		},
+	}
+}
+
+func createMetadataDeleteMessage() *Message {
+	return &Message{
+		MessageHeader: createHeader(MessageTypeMetadataDelete),
+		MessageBody: &MetadataDeleteRequest{
+			ObjectUuid: MustUUID(defaultObjectUUID),
+		},
+	}
+}
+
+func createVocabularyReadMessage() *Message {
+	return &Message{
+		MessageHeader: createHeader(MessageTypeVocabularyRead),
+		MessageBody: &VocabularyReadRequest{
+			VocabularyId: 1,
+		},
+	}
+}
+
+func createVocabularyPatchMessage() *Message {
+	return &Message{
+		MessageHeader: createHeader(MessageTypeVocabularyPatch),
+		MessageBody: &VocabularyPatchRequest{
+			VocabularyId:    1,
+			VocabularyName:  "string",
+			VocabularyTerms: []string{"string"},
+		},
+	}
+}
+
+func createResearchObject() ResearchObject {
+	return ResearchObject{
+		ObjectUuid:  MustUUID(defaultObjectUUID),
+		ObjectTitle: "string",
+		ObjectPersonRole: []PersonRole{
+			PersonRole{
+				Person: Person{
+					PersonUuid: MustUUID("27811a4c-9cb5-4e6d-a069-5c19288fae58"),
+					PersonIdentifier: []PersonIdentifier{
+						PersonIdentifier{
+							PersonIdentifierValue: "string",
+							PersonIdentifierType:  PersonIdentifierTypeEnum_ORCID,
 						},
-						RelationType: RelationTypeEnum_cites,
 					},
-				},
-				ObjectOrganisationRole: []OrganisationRole{
-					OrganisationRole{
+					PersonHonorificPrefix: "string",
+					PersonGivenNames:      "string",
+					PersonFamilyNames:     "string",
+					PersonHonorificSuffix: "string",
+					PersonMail:            "person@net",
+					PersonOrganisationUnit: OrganisationUnit{
+						OrganisationUnitUuid: MustUUID("28be7f16-0e70-461f-a2db-d9d7c64a8f17"),
+						OrganisationUuidName: "string",
 						Organisation: Organisation{
 							OrganisationJiscId:  1,
 							OrganisationName:    "string",
 							OrganisationType:    OrganisationTypeEnum_charity,
 							OrganisationAddress: "string",
 						},
-						Role: OrganisationRoleEnum_funder,
 					},
 				},
-				ObjectPreservationEvent: []PreservationEvent{
-					PreservationEvent{
-						PreservationEventValue:  "string",
-						PreservationEventType:   PreservationEventTypeEnum_capture,
-						PreservationEventDetail: "string",
-					},
+				Role: PersonRoleEnum_administrator,
+			},
+		},
+		ObjectDescription: "string",
+		ObjectRights: Rights{
+			RightsStatement: []string{"string"},
+			RightsHolder:    []string{"string"},
+			Licence: []Licence{
+				Licence{
+					LicenceName:       "string",
+					LicenceIdentifier: "string",
+					LicenseStartDate:  Timestamp(time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)),
+					LicenseEndDate:    Timestamp(time.Date(2018, time.December, 31, 23, 59, 59, 0, time.UTC)),
+				},
+			},
+			Access: []Access{
+				Access{
+					AccessType:      AccessTypeEnum_open,
+					AccessStatement: "string",
+				},
+			},
+		},
+		ObjectDate: []Date{
+			Date{
+				DateValue: "2002-10-02T10:00:00-05:00",
+				DateType:  DateTypeEnum_accepted,
+			},
+		},
+		ObjectKeywords:     []string{"string"},
+		ObjectCategory:     []string{"string"},
+		ObjectResourceType: ResourceTypeEnum_artDesignItem,
+		ObjectValue:        ObjectValueEnum_normal,
+		ObjectIdentifier: []Identifier{
+			Identifier{
+				IdentifierValue: "string",
+				IdentifierType:  1,
+			},
+		},
+		ObjectRelatedIdentifier: []IdentifierRelationship{
+			IdentifierRelationship{
+				Identifier: Identifier{
+					IdentifierValue: "string",
+					IdentifierType:  IdentifierTypeEnum_ARK,
 				},
-				ObjectFile: []File{},
+				RelationType: RelationTypeEnum_cites,
 			},
 		},
+		ObjectOrganisationRole: []OrganisationRole{
+			OrganisationRole{
+				Organisation: Organisation{
+					OrganisationJiscId:  1,
+					OrganisationName:    "string",
+					OrganisationType:    OrganisationTypeEnum_charity,
+					OrganisationAddress: "string",
+				},
+				Role: OrganisationRoleEnum_funder,
+			},
+		},
+		ObjectPreservationEvent: []PreservationEvent{
+			PreservationEvent{
+				PreservationEventValue:  "string",
+				PreservationEventType:   PreservationEventTypeEnum_capture,
+				PreservationEventDetail: "string",
+			},
+		},
+		ObjectFile: []File{},
 	}
 }
 
-func createFile(uuid, path, title, checksum string) *File {
+func createFile(uuid, path, title string, checksums []checksum, info objectInfo) *File {
 	file := &File{
 		FileUUID:             MustUUID(uuid),
 		FileIdentifier:       uuid,
 		FileName:             title,
-		FileSize:             1,
+		FileSize:             int(info.Size),
 		FileCompositionLevel: "string",
-		FileDateModified:     []Timestamp{Timestamp(time.Date(2002, time.October, 2, 10, 0, 0, 0, time.FixedZone("", -18000)))},
+		FileDateModified:     []Timestamp{Timestamp(info.Modified)},
 		FileUse:              FileUseEnum_originalFile,
 		FilePreservationEvent: []PreservationEvent{
 			PreservationEvent{
@@ -175,17 +272,15 @@ func createFile(uuid, path, title, checksum string) *File {
 				},
 			},
 		},
-		FileDateCreated:  Timestamp(time.Date(1984, time.October, 2, 10, 0, 0, 0, time.FixedZone("", -18000))),
+		FileDateCreated:  Timestamp(info.Created),
 		FileLastDownload: Timestamp(time.Date(2012, time.October, 2, 10, 0, 0, 0, time.FixedZone("", -18000))),
 	}
-	if checksum != "" {
-		file.FileChecksum = []Checksum{
-			Checksum{
-				ChecksumUuid:  NewUUID(),
-				ChecksumType:  ChecksumTypeEnum_md5,
-				ChecksumValue: checksum,
-			},
-		}
+	for _, sum := range checksums {
+		file.FileChecksum = append(file.FileChecksum, Checksum{
+			ChecksumUuid:  NewUUID(),
+			ChecksumType:  sum.Type,
+			ChecksumValue: sum.Value,
+		})
 	}
 	return file
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// listObjects lists the objects under a prefix. A single page of up to
+// s3MaxKeys objects is returned unless all is true, in which case the
+// continuation tokens are followed until the listing is exhausted or
+// s3MaxObjects objects are found. The token returned can be used to continue
+// the listing, it is empty when there are no more objects.
+func listObjects(bucket, prefix, token string, all bool) ([]*s3.Object, string, error) {
+	client := activeConnection().s3
+	objects := []*s3.Object{}
+	for {
+		maxKeys := *s3MaxKeys
+		if all {
+			maxKeys = *s3MaxObjects - int64(len(objects))
+			if maxKeys > 1000 {
+				maxKeys = 1000
+			}
+		}
+		req := &s3.ListObjectsV2Input{
+			Bucket:  aws.String(bucket),
+			MaxKeys: aws.Int64(maxKeys),
+			Prefix:  aws.String(prefix),
+		}
+		if token != "" {
+			req.ContinuationToken = aws.String(token)
+		}
+		resp, err := client.ListObjectsV2(req)
+		if err != nil {
+			return nil, "", err
+		}
+		objects = append(objects, resp.Contents...)
+		token = ""
+		if resp.IsTruncated != nil && *resp.IsTruncated && resp.NextContinuationToken != nil {
+			token = *resp.NextContinuationToken
+		}
+		if !all || token == "" || int64(len(objects)) >= *s3MaxObjects {
+			return objects, token, nil
+		}
+	}
+}
+
+// listFolder lists the immediate contents of a prefix using "/" as the
+// delimiter, i.e. the sub-folders (common prefixes) and the objects found at
+// that level, up to s3MaxObjects entries.
+func listFolder(bucket, prefix string) ([]string, []*s3.Object, error) {
+	var (
+		folders = []string{}
+		objects = []*s3.Object{}
+		token   string
+		client  = activeConnection().s3
+	)
+	for {
+		req := &s3.ListObjectsV2Input{
+			Bucket:    aws.String(bucket),
+			Delimiter: aws.String("/"),
+			MaxKeys:   aws.Int64(1000),
+			Prefix:    aws.String(prefix),
+		}
+		if token != "" {
+			req.ContinuationToken = aws.String(token)
+		}
+		resp, err := client.ListObjectsV2(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, item := range resp.CommonPrefixes {
+			folders = append(folders, *item.Prefix)
+		}
+		objects = append(objects, resp.Contents...)
+		if resp.IsTruncated == nil || !*resp.IsTruncated || resp.NextContinuationToken == nil {
+			break
+		}
+		if int64(len(folders)+len(objects)) >= *s3MaxObjects {
+			break
+		}
+		token = *resp.NextContinuationToken
+	}
+	return folders, objects, nil
+}
+
+// objectInfo describes the S3 object that a File entry refers to.
+type objectInfo struct {
+	Size     int64
+	Created  time.Time
+	Modified time.Time
+}
+
+// createdMetadataKey is the user-defined metadata entry (`x-amz-meta-created`)
+// used as the creation date of the object. S3 does not record creation dates
+// so we fall back to the modification date when it is missing.
+const createdMetadataKey = "Created"
+
+// describeObject returns the size and the dates of an object listed by
+// ListObjectsV2. The listing is only complemented with a HEAD request when it
+// misses an attribute or when the user-defined metadata is needed, see
+// -s3-created-metadata.
+func describeObject(client *s3.S3, bucket string, object *s3.Object) objectInfo {
+	info := objectInfo{}
+	if object.Size != nil {
+		info.Size = *object.Size
+	}
+	if object.LastModified != nil {
+		info.Modified = *object.LastModified
+	}
+	if object.Size != nil && object.LastModified != nil && !*s3CreatedMeta {
+		info.Created = info.Modified
+		return info
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    object.Key,
+	})
+	if err != nil {
+		log.Printf("[ERROR] S3 HEAD request failed (bucket=%s key=%s): %s", bucket, *object.Key, err)
+	} else {
+		if object.Size == nil && resp.ContentLength != nil {
+			info.Size = *resp.ContentLength
+		}
+		if object.LastModified == nil && resp.LastModified != nil {
+			info.Modified = *resp.LastModified
+		}
+		if value, ok := resp.Metadata[createdMetadataKey]; ok && value != nil {
+			if created, err := time.Parse(time.RFC3339, *value); err == nil {
+				info.Created = created
+			} else {
+				log.Printf("[ERROR] Creation date could not be parsed (bucket=%s key=%s): %s", bucket, *object.Key, err)
+			}
+		}
+	}
+
+	if info.Created.IsZero() {
+		info.Created = info.Modified
+	}
+	return info
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
+)
+
+// objectFilter selects the objects of a listing.
+type objectFilter struct {
+	Include []string
+	Exclude []string
+	MinSize int64
+	MaxSize int64 // Zero means no limit.
+}
+
+// parseObjectFilter reads the filter from the query string, e.g.:
+//
+//	?include=*.tif,*.jpg&exclude=thumbs/*&min-size=1024&max-size=1048576
+func parseObjectFilter(values url.Values) (objectFilter, error) {
+	var (
+		f   objectFilter
+		err error
+	)
+	f.Include = splitPatterns(values.Get("include"))
+	f.Exclude = splitPatterns(values.Get("exclude"))
+	for _, pattern := range append(f.Include, f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return f, fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	if value := values.Get("min-size"); value != "" {
+		if f.MinSize, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return f, fmt.Errorf("invalid minimum size %q", value)
+		}
+	}
+	if value := values.Get("max-size"); value != "" {
+		if f.MaxSize, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return f, fmt.Errorf("invalid maximum size %q", value)
+		}
+	}
+	return f, nil
+}
+
+func splitPatterns(value string) []string {
+	patterns := []string{}
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// matchPatterns reports whether the key or its base name match any of the
+// patterns so "*.txt" matches "dataset/notes.txt".
+func matchPatterns(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(key)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the object is selected by the filter.
+func (f objectFilter) Match(object *s3.Object) bool {
+	if len(f.Include) > 0 && !matchPatterns(f.Include, *object.Key) {
+		return false
+	}
+	if matchPatterns(f.Exclude, *object.Key) {
+		return false
+	}
+	var size int64
+	if object.Size != nil {
+		size = *object.Size
+	}
+	if size < f.MinSize {
+		return false
+	}
+	if f.MaxSize > 0 && size > f.MaxSize {
+		return false
+	}
+	return true
+}
+
+const browseHTML = `{{template "header" .}}
+		<h3>Pick the files of the dataset.</h3>
+		<p>Objects found under <code>s3://{{.Bucket}}/{{.Prefix}}</code>, up to {{.MaxObjects}}. Patterns are matched against the key and its base name, e.g. <code>*.tif</code>.</p>
+		<form method="GET">
+			<div class="row">
+				<div class="column"><label>Include</label><input type="text" name="include" value="{{.Include}}" placeholder="*.tif,*.jpg" /></div>
+				<div class="column"><label>Exclude</label><input type="text" name="exclude" value="{{.Exclude}}" placeholder="thumbs/*" /></div>
+				<div class="column"><label>Min. size (bytes)</label><input type="number" name="min-size" value="{{.MinSize}}" /></div>
+				<div class="column"><label>Max. size (bytes)</label><input type="number" name="max-size" value="{{.MaxSize}}" /></div>
+			</div>
+			<button type="submit" class="button button-outline">Filter</button>
+		</form>
+		{{if .Error}}
+			<div class="error"><p>{{.Error}}</p></div>
+		{{else}}
+			<p>{{len .Objects}} of {{.Total}} objects match the filters.</p>
+			<form method="POST">
+				<table>
+					<thead>
+						<tr><th></th><th>Key</th><th>Size</th><th>Last modified</th></tr>
+					</thead>
+					<tbody>
+						{{range .Objects}}
+							<tr>
+								<td><input type="checkbox" name="key" value="{{.Key}}" checked /></td>
+								<td><code>{{.Key}}</code></td>
+								<td>{{.Size}}</td>
+								<td>{{.LastModified}}</td>
+							</tr>
+						{{end}}
+					</tbody>
+				</table>
+				<button type="submit" class="button">Compose message</button>
+			</form>
+		{{end}}
+{{template "footer" .}}`
+
+var browseTmpl = template.Must(template.Must(template.New("browse").Funcs(layoutFuncs).Parse(browseHTML)).Parse(layout))
+
+func browseHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	query := strings.TrimPrefix(r.URL.Path, "/browse/")
+	if query == "" {
+		redirect(w, r, "/browse/"+activeTarget().S3DefaultBucket+"/", http.StatusFound)
+		return
+	}
+	bucket, keyPrefix := splitBucketPrefix(query)
+
+	log.Printf("Accessing to S3, bucket=%s prefix=%s all=true", bucket, keyPrefix)
+	objects, _, err := listObjects(bucket, keyPrefix, "", true)
+	if err != nil {
+		log.Printf("[ERROR] S3 not available! bucket=%s prefix=%s - %s", bucket, keyPrefix, err)
+	}
+
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, fmt.Sprintf("The form could not be parsed: %s", err), http.StatusBadRequest)
+			return
+		}
+		req := generateRequest{
+			Type:      message.MessageTypeMetadataCreate.String(),
+			Bucket:    bucket,
+			Prefix:    keyPrefix,
+			Keys:      r.PostForm["key"],
+			Checksums: *checksums,
+		}
+		renderFormWithObjects(w, r, req, pickObjects(objects, req.Keys), &Page{
+			Prefix:      keyPrefix,
+			Bucket:      bucket,
+			S3Available: err == nil,
+			Picked:      true,
+		})
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data := struct {
+		Bucket     string
+		Prefix     string
+		MaxObjects int64
+		Include    string
+		Exclude    string
+		MinSize    string
+		MaxSize    string
+		Total      int
+		Objects    []*s3.Object
+		Error      string
+	}{
+		Bucket:     bucket,
+		Prefix:     keyPrefix,
+		MaxObjects: *s3MaxObjects,
+		Include:    r.URL.Query().Get("include"),
+		Exclude:    r.URL.Query().Get("exclude"),
+		MinSize:    r.URL.Query().Get("min-size"),
+		MaxSize:    r.URL.Query().Get("max-size"),
+		Total:      len(objects),
+		Objects:    []*s3.Object{},
+	}
+	filter, ferr := parseObjectFilter(r.URL.Query())
+	switch {
+	case err != nil:
+		data.Error = "An error occurred trying to access S3! See the logs for more details."
+	case ferr != nil:
+		data.Error = ferr.Error()
+	default:
+		for _, object := range objects {
+			if filter.Match(object) {
+				data.Objects = append(data.Objects, object)
+			}
+		}
+	}
+
+	if err := executeTemplate(w, r, browseTmpl, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// forwardedPrefixRe matches the values of X-Forwarded-Prefix that we accept,
+// anything else is ignored so the header can't inject markup or redirect the
+// user to a different host.
+var forwardedPrefixRe = regexp.MustCompile("^(/[0-9A-Za-z._~-]+)+/?$")
+
+// forwardedPrefix returns the prefix stripped by a reverse proxy, without the
+// trailing slash, or an empty string if the header is missing or refused.
+func forwardedPrefix(r *http.Request) string {
+	fwd := r.Header.Get("X-Forwarded-Prefix")
+	if !forwardedPrefixRe.MatchString(fwd) {
+		return ""
+	}
+	for _, segment := range strings.Split(strings.Trim(fwd, "/"), "/") {
+		if segment == "." || segment == ".." {
+			return ""
+		}
+	}
+	return strings.TrimSuffix(fwd, "/")
+}
+
+// basePath returns the path under which the user reaches msgcreator, always
+// with a trailing slash, e.g. "/msgcreator/". It is the prefix stripped by a
+// reverse proxy (X-Forwarded-Prefix) followed by -prefix.
+func basePath(r *http.Request) string {
+	base := *prefix
+	if r == nil {
+		return base
+	}
+	return forwardedPrefix(r) + base
+}
+
+// urlFor returns the path of a page of msgcreator as seen by the user, e.g.
+// "/sent" becomes "/msgcreator/sent". The path never starts with "//", which
+// the browsers would take for a different host.
+func urlFor(r *http.Request, p string) string {
+	return basePath(r) + strings.TrimLeft(p, "/")
+}
+
+// redirect sends the user to a page of msgcreator. The location is only a
+// path, the browser resolves it against the public origin so we don't need
+// to know the host and the scheme seen through a reverse proxy, i.e.
+// X-Forwarded-Proto and X-Forwarded-Host are not used.
+func redirect(w http.ResponseWriter, r *http.Request, p string, code int) {
+	http.Redirect(w, r, urlFor(r, p), code)
+}
+
+// executeTemplate renders a page with the url function bound to the request.
+// Templates are cloned because html/template doesn't allow to change the
+// functions of a template once it has been executed.
+func executeTemplate(w io.Writer, r *http.Request, t *template.Template, data interface{}) error {
+	t, err := t.Clone()
+	if err != nil {
+		return err
+	}
+	t.Funcs(template.FuncMap{
+		"url": func(p string) string { return urlFor(r, p) },
+	})
+	return t.Execute(w, data)
+}
+
+// withPrefix serves the handler under -prefix, the handler sees the paths
+// without it.
+func withPrefix(h http.Handler) http.Handler {
+	if *prefix == "/" {
+		return h
+	}
+	mux := http.NewServeMux()
+	mux.Handle(*prefix, http.StripPrefix(strings.TrimSuffix(*prefix, "/"), h))
+	return mux
+}
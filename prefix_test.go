@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestBasePath(t *testing.T) {
+	defer func(p *string) { prefix = p }(prefix)
+	tests := []struct {
+		prefix    string
+		forwarded string
+		want      string
+	}{
+		{"/", "", "/"},
+		{"/msgcreator/", "", "/msgcreator/"},
+		{"/", "/tools", "/tools/"},
+		{"/msgcreator/", "/tools/", "/tools/msgcreator/"},
+		{"/msgcreator/", "/a/b.c/d_e~f-g", "/a/b.c/d_e~f-g/msgcreator/"},
+		{"/msgcreator/", "/.well-known/...x", "/.well-known/...x/msgcreator/"},
+
+		// Hostile values are ignored.
+		{"/msgcreator/", "//evil.example.com", "/msgcreator/"},
+		{"/", "//evil.example.com/", "/"},
+		{"/", "https://evil.example.com", "/"},
+		{"/", `/\evil.example.com`, "/"},
+		{"/", "/tools//evil", "/"},
+		{"/", "tools", "/"},
+		{"/", "/", "/"},
+		{"/", "/..", "/"},
+		{"/", "/tools/../..", "/"},
+		{"/", "/./tools", "/"},
+		{"/", `/"><script>alert(1)</script>`, "/"},
+		{"/", "/tools?x=1", "/"},
+		{"/", "/tools#x", "/"},
+		{"/", "/tools%2F%2Fevil", "/"},
+		{"/", "/tools\r\nLocation: https://evil.example.com", "/"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.forwarded, func(t *testing.T) {
+			prefix = &tc.prefix
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header["X-Forwarded-Prefix"] = []string{tc.forwarded}
+			if got := basePath(r); got != tc.want {
+				t.Errorf("basePath() with X-Forwarded-Prefix %q = %q, want %q", tc.forwarded, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestURLFor(t *testing.T) {
+	defer func(p *string) { prefix = p }(prefix)
+	base := "/"
+	prefix = &base
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/"},
+		{"/sent", "/sent"},
+		{"//evil.example.com", "/evil.example.com"},
+		{"browse/", "/browse/"},
+	}
+	for _, tc := range tests {
+		if got := urlFor(nil, tc.path); got != tc.want {
+			t.Errorf("urlFor(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestRedirectIsPathOnly(t *testing.T) {
+	defer func(p *string) { prefix = p }(prefix)
+	base := "/msgcreator/"
+	prefix = &base
+	tests := []struct {
+		path    string
+		headers map[string]string
+		want    string
+	}{
+		{"/sent", nil, "/msgcreator/sent"},
+		{"/sent", map[string]string{"X-Forwarded-Proto": "https"}, "/msgcreator/sent"},
+		{"/sent", map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "public.example.com", "X-Forwarded-Prefix": "/tools"}, "/tools/msgcreator/sent"},
+		{"/sent", map[string]string{"X-Forwarded-Host": "evil.example.com", "X-Forwarded-Prefix": "//evil.example.com"}, "/msgcreator/sent"},
+		{"//evil.example.com", map[string]string{"X-Forwarded-Proto": "https"}, "/msgcreator/evil.example.com"},
+		{"/", map[string]string{"X-Forwarded-Prefix": `/\evil.example.com`}, "/msgcreator/"},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest("POST", "http://internal:8000/msgcreator/", nil)
+		for name, value := range tc.headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		redirect(w, r, tc.path, http.StatusSeeOther)
+		location := w.Header().Get("Location")
+		u, err := url.Parse(location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Scheme != "" || u.Host != "" || !strings.HasPrefix(location, "/") || strings.HasPrefix(location, "//") {
+			t.Errorf("redirect(%q) with %v: Location %q has a scheme or a host", tc.path, tc.headers, location)
+		}
+		if location != tc.want {
+			t.Errorf("redirect(%q) with %v: Location %q, want %q", tc.path, tc.headers, location, tc.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Publisher sends messages to a destination, e.g. a Kinesis stream. It plays
+// the role of backend.Backend in the channel adapter, which we can't import
+// because its dependencies are not vendored here.
+type Publisher interface {
+	Publish(ctx context.Context, data []byte) (*receipt, error)
+}
+
+// receipt describes where a message was published.
+type receipt struct {
+	ShardID         string
+	SequenceNumber  string
+	PartitionKey    string
+	ExplicitHashKey string
+	Stream          string
+
+	// DryRun is true when the message was not sent, Data and Size describe
+	// the record that would have been sent.
+	DryRun bool
+	Data   []byte
+	Size   int
+
+	// Detail is a human-readable description of the destination.
+	Detail string
+}
+
+// publisherConstructor is a function that initializes and returns a Publisher
+// implementation for the connection of a target with the given options.
+type publisherConstructor func(c *connection, opts map[string]string) (Publisher, error)
+
+var publisherRegistration = make(map[string]publisherConstructor)
+
+// registerPublisher registers a new publisher under a name. It is typically
+// used in init functions.
+func registerPublisher(name string, fn publisherConstructor) {
+	if _, exists := publisherRegistration[name]; exists {
+		panic(fmt.Sprintf("publisher %q already exists", name))
+	}
+	publisherRegistration[name] = fn
+}
+
+// publisherNames returns the names of the registered publishers.
+func publisherNames() []string {
+	names := []string{}
+	for name := range publisherRegistration {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dialPublisher returns the named publisher. The options are given in the
+// format "key1=value1,key2=value2,..." like backend.WithOptions does.
+func dialPublisher(c *connection, name, options string) (Publisher, error) {
+	fn, found := publisherRegistration[name]
+	if !found {
+		return nil, fmt.Errorf("unknown publisher %q, choose one of: %s", name, strings.Join(publisherNames(), ", "))
+	}
+	opts := make(map[string]string)
+	if options != "" {
+		for _, p := range strings.Split(options, ",") {
+			kv := strings.SplitN(p, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("error parsing option %s", kv)
+			}
+			opts[kv[0]] = kv[1]
+		}
+	}
+	return fn(c, opts)
+}
+
+func init() {
+	registerPublisher("stdout", newStdoutPublisher)
+	registerPublisher("file", newFilePublisher)
+	registerPublisher("webhook", newWebhookPublisher)
+}
+
+// compactMessage removes the insignificant whitespace of a message so it fits
+// in a single line, as needed by the JSONL format.
+func compactMessage(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, data); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// stdoutPublisher writes the messages to the standard output, one per line.
+type stdoutPublisher struct {
+	mu sync.Mutex
+}
+
+func newStdoutPublisher(c *connection, opts map[string]string) (Publisher, error) {
+	return &stdoutPublisher{}, nil
+}
+
+func (p *stdoutPublisher) Publish(ctx context.Context, data []byte) (*receipt, error) {
+	line, err := compactMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := os.Stdout.Write(line); err != nil {
+		return nil, err
+	}
+	return &receipt{Detail: "Written to the standard output"}, nil
+}
+
+// filePublisher appends the messages to a file in the JSONL format. Options:
+// "path" (required).
+type filePublisher struct {
+	path string
+	mu   sync.Mutex
+}
+
+func newFilePublisher(c *connection, opts map[string]string) (Publisher, error) {
+	path := opts["path"]
+	if path == "" {
+		return nil, fmt.Errorf("file publisher: option path is undefined")
+	}
+	return &filePublisher{path: path}, nil
+}
+
+func (p *filePublisher) Publish(ctx context.Context, data []byte) (*receipt, error) {
+	line, err := compactMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, err := os.OpenFile(p.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return &receipt{Detail: fmt.Sprintf("Appended to %s", p.path)}, nil
+}
+
+// webhookPublisher sends the messages in the body of HTTP POST requests.
+// Options: "url" (required).
+type webhookPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookPublisher(c *connection, opts map[string]string) (Publisher, error) {
+	url := opts["url"]
+	if url == "" {
+		return nil, fmt.Errorf("webhook publisher: option url is undefined")
+	}
+	return &webhookPublisher{url: url, client: &http.Client{}}, nil
+}
+
+func (p *webhookPublisher) Publish(ctx context.Context, data []byte) (*receipt, error) {
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("webhook %s returned %s", p.url, resp.Status)
+	}
+	return &receipt{Detail: fmt.Sprintf("Posted to %s (%s)", p.url, resp.Status)}, nil
+}
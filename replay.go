@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
+)
+
+// replayOptions controls how a capture is replayed.
+type replayOptions struct {
+	// RegenerateIDs gives each message a new messageId. Messages that shared
+	// a messageSequence keep sharing a new one.
+	RegenerateIDs bool
+
+	// Speed compresses the original timing, e.g. 10 replays ten times faster
+	// than the messages were published. Zero sends them without waiting.
+	Speed float64
+
+	// Types are the message types replayed, all of them when empty.
+	Types map[string]bool
+}
+
+// parseReplayOptions reads the options given as in the command line, e.g.:
+// ids=regenerate, speed=10, types=MetadataCreate,MetadataUpdate.
+func parseReplayOptions(ids, speed, types string) (replayOptions, error) {
+	opts := replayOptions{Types: make(map[string]bool)}
+	switch ids {
+	case "", "keep":
+	case "regenerate":
+		opts.RegenerateIDs = true
+	default:
+		return opts, fmt.Errorf("unknown replay IDs mode %q, choose one of: keep, regenerate", ids)
+	}
+	if speed != "" {
+		var err error
+		if opts.Speed, err = strconv.ParseFloat(speed, 64); err != nil || opts.Speed < 0 {
+			return opts, fmt.Errorf("invalid replay speed %q", speed)
+		}
+	}
+	for _, t := range splitPatterns(types) {
+		opts.Types[t] = true
+	}
+	return opts, nil
+}
+
+// replayRecord is the outcome of replaying one line of the capture.
+type replayRecord struct {
+	Line        int
+	MessageType string
+	MessageID   string
+	Receipt     *receipt
+	Skipped     bool
+	Error       string
+}
+
+// replay publishes the messages captured in r, one per line, with
+// sendMessage. The messages are not validated so invalid messages can be
+// reproduced too. The callback is invoked after each line.
+func replay(ctx context.Context, r io.Reader, opts replayOptions, fn func(*replayRecord)) error {
+	var (
+		scanner   = bufio.NewScanner(r)
+		sequences = make(map[string]string)
+		previous  time.Time
+	)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		record := &replayRecord{Line: n}
+		if err := replayLine(ctx, record, line, opts, sequences, &previous); err != nil {
+			record.Error = err.Error()
+		}
+		fn(record)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return scanner.Err()
+}
+
+func replayLine(ctx context.Context, record *replayRecord, line []byte, opts replayOptions, sequences map[string]string, previous *time.Time) error {
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return fmt.Errorf("the message could not be decoded: %s", err)
+	}
+	header, _ := doc["messageHeader"].(map[string]interface{})
+	record.MessageType, _ = header["messageType"].(string)
+	record.MessageID, _ = header["messageId"].(string)
+	if len(opts.Types) > 0 && !opts.Types[record.MessageType] {
+		record.Skipped = true
+		return nil
+	}
+
+	// Wait as long as the original publisher did, compressed by the speed.
+	if timings, ok := header["messageTimings"].(map[string]interface{}); ok && opts.Speed > 0 {
+		value, _ := timings["publishedTimestamp"].(string)
+		if published, err := time.Parse(time.RFC3339, value); err == nil {
+			if !previous.IsZero() && published.After(*previous) {
+				select {
+				case <-time.After(time.Duration(float64(published.Sub(*previous)) / opts.Speed)):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			*previous = published
+		}
+	}
+
+	if opts.RegenerateIDs && header != nil {
+		record.MessageID = regenerateIDs(header, sequences)
+		var err error
+		if line, err = json.Marshal(doc); err != nil {
+			return err
+		}
+	}
+
+	sctx, cancel := context.WithTimeout(ctx, time.Second*4)
+	defer cancel()
+	rcpt, err := sendMessage(sctx, string(line))
+	if err != nil {
+		return fmt.Errorf("the message could not be sent: %s", err)
+	}
+	record.Receipt = rcpt
+	return nil
+}
+
+// regenerateIDs gives the message header a new messageId, which is returned.
+// The messages that shared a messageSequence keep sharing a new one, the
+// sequences map remembers the sequences replaced.
+func regenerateIDs(header map[string]interface{}, sequences map[string]string) string {
+	id := message.NewUUID().String()
+	header["messageId"] = id
+	if sequence, ok := header["messageSequence"].(map[string]interface{}); ok {
+		if old, ok := sequence["sequence"].(string); ok {
+			if _, found := sequences[old]; !found {
+				sequences[old] = message.NewUUID().String()
+			}
+			sequence["sequence"] = sequences[old]
+		}
+	}
+	return id
+}
+
+const replayHTML = `{{template "header" .}}
+		{{if .Records}}
+			<h3>Capture replayed ({{.Publisher}}).</h3>
+			{{if .Error}}<div class="error"><p>{{.Error}}</p></div>{{end}}
+			<div class="{{if .Failed}}error{{else}}result{{end}}">{{.Sent}} message(s) sent, {{.Skipped}} skipped, {{.Failed}} failed.</div>
+			<table>
+				<thead>
+					<tr><th>Line</th><th>Message</th><th>ShardId</th><th>SequenceNumber</th><th>Result</th></tr>
+				</thead>
+				<tbody>
+					{{range .Records}}
+						<tr>
+							<td>{{.Line}}</td>
+							<td><code>{{.MessageType}}</code><br /><small>{{.MessageID}}</small></td>
+							<td>{{with .Receipt}}{{.ShardID}}{{end}}</td>
+							<td>{{with .Receipt}}{{.SequenceNumber}}{{end}}</td>
+							<td>{{if .Error}}{{.Error}}{{else if .Skipped}}Skipped{{else}}Sent{{end}}</td>
+						</tr>
+					{{end}}
+				</tbody>
+			</table>
+			<hr />
+			<a href="{{url "/replay"}}">Replay another capture</a>
+		{{else}}
+			<h3>Replay a capture ({{.Publisher}}).</h3>
+			{{if .Error}}<div class="error"><p>{{.Error}}</p></div>{{end}}
+			<p>Upload a JSONL file with one RDSS message per line. The messages are sent as they are, without validation.</p>
+			<form method="POST" enctype="multipart/form-data">
+				<label>Capture</label>
+				<input type="file" name="capture" />
+				<div class="row">
+					<div class="column">
+						<label>Message IDs</label>
+						<select name="ids">
+							<option value="keep">Keep the original IDs</option>
+							<option value="regenerate">Regenerate the IDs</option>
+						</select>
+					</div>
+					<div class="column"><label>Speed</label><input type="number" name="speed" step="any" min="0" value="0" title="e.g. 10 replays ten times faster than the original timing, 0 sends the messages without waiting" /></div>
+					<div class="column"><label>Message types</label><input type="text" name="types" placeholder="MetadataCreate,MetadataUpdate" /></div>
+				</div>
+				<button type="submit" class="button">Replay</button>
+			</form>
+		{{end}}
+{{template "footer" .}}`
+
+var replayTmpl = template.Must(template.Must(template.New("replay").Funcs(layoutFuncs).Parse(replayHTML)).Parse(layout))
+
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	data := struct {
+		Publisher string
+		Error     string
+		Records   []*replayRecord
+		Sent      int
+		Skipped   int
+		Failed    int
+	}{
+		Publisher: *publisherName,
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			data.Error = fmt.Sprintf("The form could not be parsed: %s", err)
+			break
+		}
+		opts, err := parseReplayOptions(r.FormValue("ids"), r.FormValue("speed"), r.FormValue("types"))
+		if err != nil {
+			data.Error = err.Error()
+			break
+		}
+		file, _, err := r.FormFile("capture")
+		if err != nil {
+			data.Error = fmt.Sprintf("The capture could not be read: %s", err)
+			break
+		}
+		defer file.Close()
+		err = replay(r.Context(), file, opts, func(record *replayRecord) {
+			data.Records = append(data.Records, record)
+			switch {
+			case record.Error != "":
+				data.Failed++
+			case record.Skipped:
+				data.Skipped++
+			default:
+				data.Sent++
+			}
+		})
+		if err != nil {
+			data.Error = fmt.Sprintf("The capture could not be replayed: %s", err)
+		}
+		log.Printf("Capture replayed: sent=%d skipped=%d failed=%d", data.Sent, data.Skipped, data.Failed)
+		if len(data.Records) == 0 && data.Error == "" {
+			data.Error = "The capture is empty."
+		}
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := executeTemplate(w, r, replayTmpl, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// replayFile replays a capture from the command line.
+func replayFile(path string, opts replayOptions) error {
+	f, err := openCapture(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var failed int
+	err = replay(context.Background(), f, opts, func(record *replayRecord) {
+		switch {
+		case record.Error != "":
+			failed++
+			log.Printf("[ERROR] line=%d type=%s id=%s: %s", record.Line, record.MessageType, record.MessageID, record.Error)
+		case record.Skipped:
+			log.Printf("Skipped: line=%d type=%s id=%s", record.Line, record.MessageType, record.MessageID)
+		default:
+			log.Printf("Sent: line=%d type=%s id=%s shard=%s sequence=%s", record.Line, record.MessageType, record.MessageID, record.Receipt.ShardID, record.Receipt.SequenceNumber)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d message(s) could not be sent", failed)
+	}
+	return nil
+}
+
+// openCapture opens the capture, "-" is the standard input.
+func openCapture(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
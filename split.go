@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
+)
+
+// maxMessageSize is the largest message that fits in a Kinesis record once
+// the partition key, up to 256 bytes, is added.
+const maxMessageSize = kinesisMaxRecordSize - 256
+
+// splitMessage splits the objectFile list of a research object across as many
+// messages as needed so each of them fits in limit bytes. The parts share a
+// new messageSequence with the right position and total, as the RDSS spec
+// expects from multi-part messages. The message is returned as it is when it
+// fits already.
+func splitMessage(blob []byte, limit int) ([][]byte, error) {
+	if len(blob) <= limit {
+		return [][]byte{blob}, nil
+	}
+
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(blob))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	header, _ := doc["messageHeader"].(map[string]interface{})
+	body, _ := doc["messageBody"].(map[string]interface{})
+	files, _ := body["objectFile"].([]interface{})
+	if header == nil || len(files) < 2 {
+		return nil, fmt.Errorf("the message is %d bytes and it can't be split, only research objects with several files can", len(blob))
+	}
+
+	// Measure the message without files, then pack as many files as possible
+	// in each part. The header is measured with the largest position and
+	// total that we can get, and with a new messageId if the original one is
+	// shorter.
+	messageID, _ := header["messageId"].(string)
+	if newID := message.NewUUID().String(); len(messageID) < len(newID) {
+		header["messageId"] = newID
+	}
+	body["objectFile"] = []interface{}{}
+	header["messageSequence"] = map[string]interface{}{
+		"sequence": message.NewUUID().String(),
+		"position": len(files),
+		"total":    len(files),
+	}
+	empty, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	chunks := [][]interface{}{}
+	current := []interface{}{}
+	size := len(empty)
+	for i, file := range files {
+		encoded, err := json.Marshal(file)
+		if err != nil {
+			return nil, err
+		}
+		n := len(encoded) + 1 // The comma.
+		if len(empty)+n > limit {
+			return nil, fmt.Errorf("file #%d alone doesn't fit in a message of %d bytes", i+1, limit)
+		}
+		if size+n > limit {
+			chunks = append(chunks, current)
+			current, size = []interface{}{}, len(empty)
+		}
+		current = append(current, file)
+		size += n
+	}
+	chunks = append(chunks, current)
+
+	sequence := message.NewUUID().String()
+	parts := make([][]byte, len(chunks))
+	for i, chunk := range chunks {
+		header["messageId"] = messageID
+		if i > 0 || messageID == "" {
+			header["messageId"] = message.NewUUID().String()
+		}
+		header["messageSequence"] = map[string]interface{}{
+			"sequence": sequence,
+			"position": i + 1,
+			"total":    len(chunks),
+		}
+		body["objectFile"] = chunk
+		if parts[i], err = json.Marshal(doc); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
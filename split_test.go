@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// splitFixture returns a message with files of the sizes given, in bytes of
+// padding.
+func splitFixture(sizes ...int) []byte {
+	files := make([]string, len(sizes))
+	for i, size := range sizes {
+		files[i] = fmt.Sprintf(`{"fileName": "%d", "padding": "%s"}`, i+1, strings.Repeat("x", size))
+	}
+	return []byte(fmt.Sprintf(`{
+  "messageHeader": {"messageId": "original", "messageType": "MetadataCreate"},
+  "messageBody": {"objectUuid": "5680e8e0-28a5-4b20-948e-fd0d08781e0b", "objectFile": [%s]}
+}`, strings.Join(files, ", ")))
+}
+
+type splitPart struct {
+	MessageHeader struct {
+		MessageID       string `json:"messageId"`
+		MessageSequence struct {
+			Sequence string `json:"sequence"`
+			Position int    `json:"position"`
+			Total    int    `json:"total"`
+		} `json:"messageSequence"`
+	} `json:"messageHeader"`
+	MessageBody struct {
+		ObjectUUID string `json:"objectUuid"`
+		ObjectFile []struct {
+			FileName string `json:"fileName"`
+		} `json:"objectFile"`
+	} `json:"messageBody"`
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		blob  []byte
+		limit int
+		files [][]string // File names of each part, nil if it fails.
+	}{
+		{
+			name:  "fits already",
+			blob:  splitFixture(100, 100),
+			limit: 1000,
+			files: [][]string{{"1", "2"}},
+		},
+		{
+			name:  "two files per part",
+			blob:  splitFixture(100, 100, 100, 100, 100),
+			limit: 600,
+			files: [][]string{{"1", "2"}, {"3", "4"}, {"5"}},
+		},
+		{
+			name:  "one file per part",
+			blob:  splitFixture(250, 250, 250),
+			limit: 600,
+			files: [][]string{{"1"}, {"2"}, {"3"}},
+		},
+		{
+			name:  "file larger than the limit",
+			blob:  splitFixture(100, 1000),
+			limit: 600,
+		},
+		{
+			name:  "single file",
+			blob:  splitFixture(1000),
+			limit: 600,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			blobs, err := splitMessage(tc.blob, tc.limit)
+			if tc.files == nil {
+				if err == nil {
+					t.Fatalf("the message was split in %d parts, want an error", len(blobs))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(blobs) != len(tc.files) {
+				t.Fatalf("got %d parts, want %d", len(blobs), len(tc.files))
+			}
+			if len(blobs) == 1 {
+				if string(blobs[0]) != string(tc.blob) {
+					t.Errorf("the message was changed")
+				}
+				return
+			}
+			var sequence string
+			ids := make(map[string]bool)
+			for i, blob := range blobs {
+				if len(blob) > tc.limit {
+					t.Errorf("part %d is %d bytes, the limit is %d", i+1, len(blob), tc.limit)
+				}
+				var part splitPart
+				if err := json.Unmarshal(blob, &part); err != nil {
+					t.Fatal(err)
+				}
+				header := part.MessageHeader
+				if i == 0 {
+					sequence = header.MessageSequence.Sequence
+					if header.MessageID != "original" {
+						t.Errorf("part 1 has messageId %s, want the original", header.MessageID)
+					}
+				}
+				if header.MessageSequence.Sequence == "" || header.MessageSequence.Sequence != sequence {
+					t.Errorf("part %d has sequence %q, want %q", i+1, header.MessageSequence.Sequence, sequence)
+				}
+				if header.MessageSequence.Position != i+1 || header.MessageSequence.Total != len(blobs) {
+					t.Errorf("part %d is at position %d of %d", i+1, header.MessageSequence.Position, header.MessageSequence.Total)
+				}
+				if ids[header.MessageID] {
+					t.Errorf("part %d reuses messageId %s", i+1, header.MessageID)
+				}
+				ids[header.MessageID] = true
+				if part.MessageBody.ObjectUUID == "" {
+					t.Errorf("part %d lost the objectUuid", i+1)
+				}
+				names := []string{}
+				for _, file := range part.MessageBody.ObjectFile {
+					names = append(names, file.FileName)
+				}
+				if strings.Join(names, ",") != strings.Join(tc.files[i], ",") {
+					t.Errorf("part %d has files %v, want %v", i+1, names, tc.files[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// recordHeader is the part of the message header summarized by the stream
+// viewer. It is decoded leniently, unlike message.MessageHeader, so the
+// records of the invalid queue can be shown too.
+type recordHeader struct {
+	MessageID        string `json:"messageId"`
+	CorrelationID    string `json:"correlationId"`
+	MessageClass     string `json:"messageClass"`
+	MessageType      string `json:"messageType"`
+	Version          string `json:"version"`
+	Generator        string `json:"generator"`
+	ErrorCode        string `json:"errorCode"`
+	ErrorDescription string `json:"errorDescription"`
+	MessageTimings   struct {
+		PublishedTimestamp string `json:"publishedTimestamp"`
+	} `json:"messageTimings"`
+}
+
+// streamRecord is a record read from a Kinesis stream.
+type streamRecord struct {
+	ShardID        string
+	SequenceNumber string
+	PartitionKey   string
+	Arrival        time.Time
+	Header         recordHeader
+	Body           string // Pretty-printed when the data is JSON.
+	DecodeError    string
+}
+
+func decodeRecord(shardID string, r *kinesis.Record) streamRecord {
+	record := streamRecord{
+		ShardID:        shardID,
+		SequenceNumber: aws.StringValue(r.SequenceNumber),
+		PartitionKey:   aws.StringValue(r.PartitionKey),
+		Arrival:        aws.TimeValue(r.ApproximateArrivalTimestamp),
+		Body:           string(r.Data),
+	}
+	doc := struct {
+		MessageHeader recordHeader `json:"messageHeader"`
+	}{}
+	if err := json.Unmarshal(r.Data, &doc); err != nil {
+		record.DecodeError = err.Error()
+		return record
+	}
+	record.Header = doc.MessageHeader
+	buf := &bytes.Buffer{}
+	if err := json.Indent(buf, r.Data, "", "  "); err == nil {
+		record.Body = buf.String()
+	}
+	return record
+}
+
+// queueNames returns the streams used by the channel adapter: the main queue
+// and the queues where it routes the messages that it rejects.
+func queueNames(t target) []string {
+	return []string{t.KinesisStream, t.KinesisStreamInvalid, t.KinesisStreamError}
+}
+
+// listShards returns the IDs of the shards of a stream.
+func listShards(ctx context.Context, client *kinesis.Kinesis, stream string) ([]string, error) {
+	shards := []string{}
+	req := &kinesis.DescribeStreamInput{StreamName: aws.String(stream)}
+	for {
+		resp, err := client.DescribeStreamWithContext(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		for _, shard := range resp.StreamDescription.Shards {
+			shards = append(shards, aws.StringValue(shard.ShardId))
+		}
+		if !aws.BoolValue(resp.StreamDescription.HasMoreShards) || len(shards) == 0 {
+			return shards, nil
+		}
+		req.ExclusiveStartShardId = aws.String(shards[len(shards)-1])
+	}
+}
+
+// shardIterator returns an iterator positioned as requested, sequence is only
+// used by AT_SEQUENCE_NUMBER and AFTER_SEQUENCE_NUMBER.
+func shardIterator(ctx context.Context, client *kinesis.Kinesis, stream, shardID, iteratorType, sequence string) (string, error) {
+	req := &kinesis.GetShardIteratorInput{
+		StreamName:        aws.String(stream),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: aws.String(iteratorType),
+	}
+	if sequence != "" {
+		req.StartingSequenceNumber = aws.String(sequence)
+	}
+	resp, err := client.GetShardIteratorWithContext(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(resp.ShardIterator), nil
+}
+
+// readRecords reads up to limit records and returns the iterator of the next
+// ones, which is empty when the shard is closed.
+func readRecords(ctx context.Context, client *kinesis.Kinesis, shardID, iterator string, limit int64) ([]streamRecord, string, int64, error) {
+	resp, err := client.GetRecordsWithContext(ctx, &kinesis.GetRecordsInput{
+		ShardIterator: aws.String(iterator),
+		Limit:         aws.Int64(limit),
+	})
+	if err != nil {
+		return nil, "", 0, err
+	}
+	records := make([]streamRecord, len(resp.Records))
+	for i, r := range resp.Records {
+		records[i] = decodeRecord(shardID, r)
+	}
+	return records, aws.StringValue(resp.NextShardIterator), aws.Int64Value(resp.MillisBehindLatest), nil
+}
+
+var iteratorTypes = []string{
+	kinesis.ShardIteratorTypeLatest,
+	kinesis.ShardIteratorTypeTrimHorizon,
+	kinesis.ShardIteratorTypeAtSequenceNumber,
+}
+
+const streamsHTML = `{{template "header" .}}
+		<h3>Stream viewer</h3>
+		<p class="types">
+			{{range .Streams}}
+				<a href="{{url "/streams"}}?stream={{.}}" class="button{{if ne . $.Stream}} button-outline{{end}}">{{.}}</a>
+			{{end}}
+		</p>
+		<form method="GET">
+			<input type="hidden" name="stream" value="{{.Stream}}" />
+			<div class="row">
+				<div class="column">
+					<label>Shard</label>
+					<select name="shard">
+						{{range .Shards}}<option value="{{.}}"{{if eq . $.Shard}} selected{{end}}>{{.}}</option>{{end}}
+					</select>
+				</div>
+				<div class="column">
+					<label>Position</label>
+					<select name="type">
+						{{range .IteratorTypes}}<option value="{{.}}"{{if eq . $.IteratorType}} selected{{end}}>{{.}}</option>{{end}}
+					</select>
+				</div>
+				<div class="column"><label>Sequence number</label><input type="text" name="sequence" value="{{.Sequence}}" placeholder="AT_SEQUENCE_NUMBER only" /></div>
+				<div class="column"><label>Limit</label><input type="number" name="limit" value="{{.Limit}}" min="1" max="10000" /></div>
+			</div>
+			<button type="submit" class="button button-outline">Read</button>
+		</form>
+		{{if .Error}}
+			<div class="error"><p>{{.Error}}</p></div>
+		{{else}}
+			<p>{{len .Records}} record(s) read from <code>{{.Stream}}/{{.Shard}}</code>, {{.MillisBehindLatest}} ms behind the tip of the stream.
+			{{if .NextIterator}}<a href="{{url "/streams"}}?stream={{.Stream}}&amp;shard={{.Shard}}&amp;limit={{.Limit}}&amp;iterator={{.NextIterator}}">Next records</a>{{else}}The shard is closed.{{end}}</p>
+			{{range .Records}}
+				<div class="{{if or .Header.ErrorCode .DecodeError}}error{{else}}result{{end}}">
+					<strong>{{if .Header.MessageType}}{{.Header.MessageType}}{{else}}Unknown message{{end}}</strong>
+					{{if .Header.MessageClass}}({{.Header.MessageClass}}){{end}}
+					<br />messageId: <code>{{.Header.MessageID}}</code>
+					{{if .Header.CorrelationID}}<br />correlationId: <code>{{.Header.CorrelationID}}</code>{{end}}
+					{{if .Header.ErrorCode}}<br />errorCode: <code>{{.Header.ErrorCode}}</code> {{.Header.ErrorDescription}}{{end}}
+					{{if .DecodeError}}<br />The record is not a JSON document: {{.DecodeError}}{{end}}
+					<br /><small>SequenceNumber: {{.SequenceNumber}} &middot; PartitionKey: {{.PartitionKey}} &middot; Arrival: {{.Arrival.Format "2006-01-02 15:04:05"}}{{if .Header.MessageTimings.PublishedTimestamp}} &middot; Published: {{.Header.MessageTimings.PublishedTimestamp}}{{end}}{{if .Header.Version}} &middot; Version: {{.Header.Version}}{{end}}{{if .Header.Generator}} &middot; Generator: {{.Header.Generator}}{{end}}</small>
+				</div>
+				<pre><code>{{.Body}}</code></pre>
+			{{end}}
+		{{end}}
+{{template "footer" .}}`
+
+var streamsTmpl = template.Must(template.Must(template.New("streams").Funcs(layoutFuncs).Parse(streamsHTML)).Parse(layout))
+
+func streamsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	c := activeConnection()
+	query := r.URL.Query()
+	data := struct {
+		Streams            []string
+		Stream             string
+		Shards             []string
+		Shard              string
+		IteratorTypes      []string
+		IteratorType       string
+		Sequence           string
+		Limit              int64
+		Records            []streamRecord
+		NextIterator       string
+		MillisBehindLatest int64
+		Error              string
+	}{
+		Streams:       queueNames(c.target),
+		Stream:        query.Get("stream"),
+		Shard:         query.Get("shard"),
+		IteratorTypes: iteratorTypes,
+		IteratorType:  query.Get("type"),
+		Sequence:      query.Get("sequence"),
+		Limit:         25,
+	}
+	if data.Stream == "" {
+		data.Stream = c.KinesisStream
+	}
+	if data.IteratorType == "" {
+		data.IteratorType = kinesis.ShardIteratorTypeTrimHorizon
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 || limit > 10000 {
+			http.Error(w, fmt.Sprintf("Invalid limit %q", value), http.StatusBadRequest)
+			return
+		}
+		data.Limit = limit
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := func() error {
+		var err error
+		if data.Shards, err = listShards(ctx, c.kinesis, data.Stream); err != nil {
+			return err
+		}
+		if data.Shard == "" && len(data.Shards) > 0 {
+			data.Shard = data.Shards[0]
+		}
+		iterator := query.Get("iterator")
+		if iterator == "" {
+			if data.IteratorType == kinesis.ShardIteratorTypeAtSequenceNumber && data.Sequence == "" {
+				return fmt.Errorf("%s requires a sequence number", data.IteratorType)
+			}
+			if iterator, err = shardIterator(ctx, c.kinesis, data.Stream, data.Shard, data.IteratorType, data.Sequence); err != nil {
+				return err
+			}
+		}
+		data.Records, data.NextIterator, data.MillisBehindLatest, err = readRecords(ctx, c.kinesis, data.Shard, iterator, data.Limit)
+		return err
+	}()
+	if err != nil {
+		log.Printf("[ERROR] Kinesis stream could not be read (stream=%s shard=%s): %s", data.Stream, data.Shard, err)
+		data.Error = fmt.Sprintf("The stream could not be read: %s", err)
+	}
+
+	if err := executeTemplate(w, r, streamsTmpl, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// target is a stack that msgcreator can send messages to, e.g. a local
+// Compose stack, a shared dev stack or staging. The fields left empty in the
+// configuration file take the values of the command-line flags.
+type target struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Color       string `json:"color"` // Background of the banner, e.g. "#c00".
+
+	KinesisEndpoint      string `json:"kinesisEndpoint"`
+	KinesisRegion        string `json:"kinesisRegion"`
+	KinesisStream        string `json:"kinesisStream"`
+	KinesisStreamInvalid string `json:"kinesisStreamInvalid"`
+	KinesisStreamError   string `json:"kinesisStreamError"`
+	KinesisAccessKey     string `json:"kinesisAccessKey"`
+	KinesisSecretKey     string `json:"kinesisSecretKey"`
+	KinesisSessionToken  string `json:"kinesisSessionToken"`
+	KinesisProfile       string `json:"kinesisProfile"`
+
+	S3Endpoint      string `json:"s3Endpoint"`
+	S3Region        string `json:"s3Region"`
+	S3AccessKey     string `json:"s3AccessKey"`
+	S3SecretKey     string `json:"s3SecretKey"`
+	S3DefaultBucket string `json:"s3DefaultBucket"`
+}
+
+// inherit fills the empty fields with the values of the defaults.
+func (t *target) inherit(defaults target) {
+	for _, f := range []struct{ value, def *string }{
+		{&t.KinesisEndpoint, &defaults.KinesisEndpoint},
+		{&t.KinesisRegion, &defaults.KinesisRegion},
+		{&t.KinesisStream, &defaults.KinesisStream},
+		{&t.KinesisStreamInvalid, &defaults.KinesisStreamInvalid},
+		{&t.KinesisStreamError, &defaults.KinesisStreamError},
+		{&t.KinesisAccessKey, &defaults.KinesisAccessKey},
+		{&t.KinesisSecretKey, &defaults.KinesisSecretKey},
+		{&t.KinesisSessionToken, &defaults.KinesisSessionToken},
+		{&t.KinesisProfile, &defaults.KinesisProfile},
+		{&t.S3Endpoint, &defaults.S3Endpoint},
+		{&t.S3Region, &defaults.S3Region},
+		{&t.S3AccessKey, &defaults.S3AccessKey},
+		{&t.S3SecretKey, &defaults.S3SecretKey},
+		{&t.S3DefaultBucket, &defaults.S3DefaultBucket},
+	} {
+		if *f.value == "" {
+			*f.value = *f.def
+		}
+	}
+}
+
+// loadTargets reads the targets defined in a JSON file, e.g.:
+//
+//	{"targets": [{"name": "local", "kinesisEndpoint": "http://127.0.0.1:4567"}]}
+//
+// The defaults are the only target when the path is empty.
+func loadTargets(path string, defaults target) ([]target, error) {
+	if path == "" {
+		defaults.Name = "default"
+		return []target{defaults}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config := struct {
+		Targets []target `json:"targets"`
+	}{}
+	if err := json.NewDecoder(f).Decode(&config); err != nil {
+		return nil, fmt.Errorf("%s could not be decoded: %s", path, err)
+	}
+	if len(config.Targets) == 0 {
+		return nil, fmt.Errorf("%s does not define any targets", path)
+	}
+	names := make(map[string]bool)
+	for i := range config.Targets {
+		t := &config.Targets[i]
+		if t.Name == "" || names[t.Name] {
+			return nil, fmt.Errorf("%s: target #%d must have a unique name", path, i+1)
+		}
+		names[t.Name] = true
+		t.inherit(defaults)
+	}
+	return config.Targets, nil
+}
+
+// connection holds the clients and the publisher of a target. It is built
+// completely before the target is selected and it's never changed afterwards,
+// so the requests in flight keep using the one that they started with.
+type connection struct {
+	target
+	kinesis   *kinesis.Kinesis
+	s3        *s3.S3
+	publisher Publisher
+}
+
+// connect builds the clients and dials the publisher of a target.
+func connect(t target) (*connection, error) {
+	c := &connection{
+		target:  t,
+		kinesis: getKinesisClient(&t.KinesisAccessKey, &t.KinesisSecretKey, &t.KinesisSessionToken, &t.KinesisProfile, &t.KinesisRegion, &t.KinesisEndpoint),
+		s3:      getS3Client(&t.S3AccessKey, &t.S3SecretKey, &t.S3Region, &t.S3Endpoint),
+	}
+	// The publishers capture the client and the stream when they're dialed.
+	p, err := dialPublisher(c, *publisherName, *publisherOpts)
+	if err != nil {
+		return nil, err
+	}
+	c.publisher = p
+	return c, nil
+}
+
+var (
+	targets   []target
+	current   *connection
+	currentMu sync.RWMutex
+
+	// selectMu serializes the switches, the SDK can't create sessions
+	// concurrently.
+	selectMu sync.Mutex
+)
+
+// activeConnection returns the connection of the target that the messages
+// are sent to.
+func activeConnection() *connection {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current
+}
+
+// activeTarget returns the target that the messages are sent to.
+func activeTarget() target {
+	c := activeConnection()
+	if c == nil {
+		return target{}
+	}
+	return c.target
+}
+
+// selectTarget points the clients, the publisher and the queue watchers at
+// the named target. Nothing changes when the target can't be connected.
+func selectTarget(name string) error {
+	var t *target
+	for i := range targets {
+		if targets[i].Name == name {
+			t = &targets[i]
+		}
+	}
+	if t == nil {
+		return fmt.Errorf("unknown target %q", name)
+	}
+	selectMu.Lock()
+	defer selectMu.Unlock()
+	c, err := connect(*t)
+	if err != nil {
+		return err
+	}
+
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = c
+	if watching {
+		restartWatchers(c)
+	}
+	log.Printf("Target selected: %s (kinesis=%s stream=%s s3=%s bucket=%s)", t.Name, t.KinesisEndpoint, t.KinesisStream, t.S3Endpoint, t.S3DefaultBucket)
+	return nil
+}
+
+// layoutFuncs are available in all the pages.
+var layoutFuncs = template.FuncMap{
+	"activeTarget": activeTarget,
+	"targets":      func() []target { return targets },
+	// url is bound to the request by executeTemplate.
+	"url": func(p string) string { return urlFor(nil, p) },
+}
+
+// targetHandler selects the target posted and sends the user back.
+func targetHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := selectTarget(r.PostFormValue("target")); err != nil {
+		log.Printf("[ERROR] Target could not be selected: %s", err)
+		http.Error(w, fmt.Sprintf("The target could not be selected: %s", err), http.StatusBadRequest)
+		return
+	}
+	redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// sentMessage is a message sent by msgcreator that we follow until the
+// channel adapter reports an error or the grace period elapses.
+type sentMessage struct {
+	MessageID        string
+	MessageType      string
+	SentAt           time.Time
+	Receipt          *receipt
+	Queue            string // The queue where the adapter sent it back.
+	ErrorCode        string
+	ErrorDescription string
+	ReportedAt       time.Time
+}
+
+const (
+	statusWaiting  = "Waiting"
+	statusAccepted = "Accepted"
+	statusRejected = "Rejected"
+)
+
+// Status reports whether the adapter rejected the message. The adapter does
+// not confirm the messages that it accepts so they're considered accepted
+// when no error is reported within the grace period.
+func (m sentMessage) Status() string {
+	switch {
+	case m.ErrorCode != "":
+		return statusRejected
+	case time.Since(m.SentAt) > *watchGrace:
+		return statusAccepted
+	default:
+		return statusWaiting
+	}
+}
+
+// maxTrackedMessages is the number of messages that we keep in memory, the
+// oldest are forgotten first.
+const maxTrackedMessages = 1000
+
+// messageTracker keeps the messages sent, indexed by messageId.
+type messageTracker struct {
+	mu       sync.RWMutex
+	messages map[string]*sentMessage
+	order    []string
+}
+
+var tracker = &messageTracker{messages: make(map[string]*sentMessage)}
+
+// Track records a message that was just published.
+func (t *messageTracker) Track(blob []byte, rcpt *receipt) {
+	messageID, _ := recordIdentifiers(blob)
+	if messageID == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.messages[messageID]; !ok {
+		t.order = append(t.order, messageID)
+	}
+	t.messages[messageID] = &sentMessage{
+		MessageID:   messageID,
+		MessageType: messageType(blob),
+		SentAt:      time.Now(),
+		Receipt:     rcpt,
+	}
+	for len(t.order) > maxTrackedMessages {
+		delete(t.messages, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
+// Report records the error reported by the adapter, if we sent the message.
+func (t *messageTracker) Report(queue string, header recordHeader) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, ok := t.messages[header.MessageID]
+	if !ok {
+		return false
+	}
+	m.Queue = queue
+	m.ErrorCode = header.ErrorCode
+	m.ErrorDescription = header.ErrorDescription
+	if m.ErrorCode == "" {
+		// The invalid queue may receive messages without error details.
+		m.ErrorCode = "Unknown"
+	}
+	m.ReportedAt = time.Now()
+	return true
+}
+
+// Get returns a copy of the message with the given ID.
+func (t *messageTracker) Get(messageID string) (sentMessage, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	m, ok := t.messages[messageID]
+	if !ok {
+		return sentMessage{}, false
+	}
+	return *m, true
+}
+
+// List returns copies of the messages, the most recent first.
+func (t *messageTracker) List() []sentMessage {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	list := make([]sentMessage, 0, len(t.order))
+	for i := len(t.order) - 1; i >= 0; i-- {
+		list = append(list, *t.messages[t.order[i]])
+	}
+	return list
+}
+
+// watchStop is closed to stop the watchers, e.g. when the target changes.
+var watchStop chan struct{}
+
+// restartWatchers stops the current watchers, if any, and watches the queues
+// of the target given. The caller must hold currentMu.
+func restartWatchers(c *connection) {
+	if watchStop != nil {
+		close(watchStop)
+	}
+	watchStop = make(chan struct{})
+	go watchQueues(c, watchStop)
+}
+
+// watchQueues follows the invalid and error queues of the adapter from their
+// tip and reports the errors of the messages that we sent.
+func watchQueues(c *connection, stop <-chan struct{}) {
+	for _, queue := range []string{c.KinesisStreamInvalid, c.KinesisStreamError} {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		shards, err := listShards(ctx, c.kinesis, queue)
+		cancel()
+		if err != nil {
+			log.Printf("[ERROR] Queue %s could not be watched: %s", queue, err)
+			continue
+		}
+		for _, shard := range shards {
+			go watchShard(c.kinesis, queue, shard, stop)
+		}
+		log.Printf("Watching queue %s (shards=%d)", queue, len(shards))
+	}
+}
+
+// watchPollInterval keeps us under the limit of five reads per second and
+// shard shared with other consumers.
+const watchPollInterval = time.Second
+
+func watchShard(client *kinesis.Kinesis, queue, shard string, stop <-chan struct{}) {
+	var (
+		iterator string
+		last     string
+		err      error
+	)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if iterator == "" {
+			// Start from the tip, or resume after the last record read when
+			// the iterator expired.
+			if last == "" {
+				iterator, err = shardIterator(ctx, client, queue, shard, kinesis.ShardIteratorTypeLatest, "")
+			} else {
+				iterator, err = shardIterator(ctx, client, queue, shard, kinesis.ShardIteratorTypeAfterSequenceNumber, last)
+			}
+		}
+		var records []streamRecord
+		if err == nil {
+			records, iterator, _, err = readRecords(ctx, client, shard, iterator, 100)
+		}
+		cancel()
+		if err != nil {
+			log.Printf("[ERROR] Queue %s (shard=%s) could not be read: %s", queue, shard, err)
+			iterator = ""
+			if !sleepOrStop(watchPollInterval*10, stop) {
+				return
+			}
+			continue
+		}
+		for _, record := range records {
+			last = record.SequenceNumber
+			if tracker.Report(queue, record.Header) {
+				log.Printf("Message rejected by the adapter: id=%s queue=%s code=%s description=%s", record.Header.MessageID, queue, record.Header.ErrorCode, record.Header.ErrorDescription)
+			}
+		}
+		if iterator == "" {
+			log.Printf("Shard %s of queue %s is closed", shard, queue)
+			return
+		}
+		if !sleepOrStop(watchPollInterval, stop) {
+			return
+		}
+	}
+}
+
+// sleepOrStop waits for the duration given, it returns false if stop is
+// closed in the meantime.
+func sleepOrStop(d time.Duration, stop <-chan struct{}) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+const sentHTML = `{{template "header" .}}
+		<h3>Messages sent</h3>
+		<p>The last {{.Max}} messages sent are followed by messageId. A message is considered accepted when the channel adapter doesn't send it back to <code>{{.Invalid}}</code> or <code>{{.Error}}</code> within {{.Grace}}.{{if not .Watching}} <strong>The queues are not being watched</strong>, see <code>-watch-queues</code>.{{end}}</p>
+		<table>
+			<thead>
+				<tr><th>Sent</th><th>Message</th><th>Status</th><th>Error</th></tr>
+			</thead>
+			<tbody>
+				{{range .Messages}}
+					<tr>
+						<td>{{.SentAt.Format "2006-01-02 15:04:05"}}</td>
+						<td><code>{{.MessageType}}</code><br /><small><a href="{{url "/sent/"}}{{.MessageID}}">{{.MessageID}}</a></small></td>
+						<td>{{.Status}}</td>
+						<td>{{if .ErrorCode}}<code>{{.ErrorCode}}</code> {{.ErrorDescription}} <small>({{.Queue}})</small>{{end}}</td>
+					</tr>
+				{{else}}
+					<tr><td colspan="4">No messages sent yet.</td></tr>
+				{{end}}
+			</tbody>
+		</table>
+{{template "footer" .}}`
+
+const sentMessageHTML = `{{template "header" .}}
+		<h3>Message <code>{{.MessageID}}</code></h3>
+		<div class="{{if eq .Status "Rejected"}}error{{else}}result{{end}}">
+			{{if eq .Status "Rejected"}}
+				The channel adapter sent the message back to <code>{{.Queue}}</code>: <code>{{.ErrorCode}}</code> {{.ErrorDescription}}
+			{{else if eq .Status "Accepted"}}
+				The channel adapter didn't report any errors.
+			{{else}}
+				Waiting for the channel adapter...
+				<script>setTimeout(function() { window.location.reload(); }, 2000);</script>
+			{{end}}
+			<br />{{.MessageType}} sent at {{.SentAt.Format "2006-01-02 15:04:05"}}
+			{{with .Receipt}}{{if .Detail}}<br />{{.Detail}}{{end}}{{if .SequenceNumber}}<br />ShardId: {{.ShardID}} &middot; SequenceNumber: {{.SequenceNumber}}{{end}}{{end}}
+		</div>
+		<a href="{{url "/sent"}}">All the messages sent</a>
+{{template "footer" .}}`
+
+var (
+	sentTmpl        = template.Must(template.Must(template.New("sent").Funcs(layoutFuncs).Parse(sentHTML)).Parse(layout))
+	sentMessageTmpl = template.Must(template.Must(template.New("sentMessage").Funcs(layoutFuncs).Parse(sentMessageHTML)).Parse(layout))
+	sentRe          = regexp.MustCompile("^/sent/([0-9A-Za-z-]+)$")
+)
+
+func sentHandler(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Request received: method=%s path=%s", r.Method, r.URL)
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if values := sentRe.FindStringSubmatch(r.URL.Path); len(values) > 1 {
+		m, ok := tracker.Get(values[1])
+		if !ok {
+			http.Error(w, "The message was not sent by msgcreator or it has been forgotten.", http.StatusNotFound)
+			return
+		}
+		if err := executeTemplate(w, r, sentMessageTmpl, m); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if r.URL.Path != "/sent" && r.URL.Path != "/sent/" {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	data := struct {
+		Max      int
+		Invalid  string
+		Error    string
+		Grace    time.Duration
+		Watching bool
+		Messages []sentMessage
+	}{
+		Max:      maxTrackedMessages,
+		Invalid:  activeTarget().KinesisStreamInvalid,
+		Error:    activeTarget().KinesisStreamError,
+		Grace:    *watchGrace,
+		Watching: watching,
+		Messages: tracker.List(),
+	}
+	if err := executeTemplate(w, r, sentTmpl, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
+)
+
+// validationMode determines the type of message validation performed before
+// a message is sent. It mirrors broker.ValidationMode in the channel adapter.
+type validationMode int
+
+const (
+	// Messages are not sent if invalid.
+	validationModeStrict validationMode = iota
+
+	// Messages are sent but the validation issues are reported.
+	validationModeWarnings
+
+	// Message validator is disabled.
+	validationModeDisabled
+)
+
+// defaultValidationMode is used when -validation is not given. The messages
+// generated without -checksums don't have the fileChecksum required by the
+// schema, strict mode would not let them be sent.
+const defaultValidationMode = validationModeWarnings
+
+func (m validationMode) String() string {
+	switch m {
+	case validationModeWarnings:
+		return "warnings"
+	case validationModeDisabled:
+		return "disabled"
+	}
+	return "strict"
+}
+
+// parseValidationMode works like broker.Config.SetValidationMode, e.g. any
+// false boolean value disables the validator.
+func parseValidationMode(mode string) (validationMode, error) {
+	switch mode {
+	case "strict", "":
+		return validationModeStrict, nil
+	case "warnings":
+		return validationModeWarnings, nil
+	case "disabled":
+		return validationModeDisabled, nil
+	}
+	enabled, err := strconv.ParseBool(mode)
+	if err != nil {
+		return validationModeStrict, fmt.Errorf("unknown validation mode %q", mode)
+	}
+	if !enabled {
+		return validationModeDisabled, nil
+	}
+	return validationModeStrict, nil
+}
+
+// validationIssue describes an error found by the validator.
+type validationIssue struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+var validator message.Validator
+
+// The revision of gojsonschema in our vendor directory asks the schema loader
+// for references that include the JSON pointer fragment, e.g.
+// "header.json/#/definitions/header", and resolves the pointer itself once the
+// document is loaded. The finder of the channel adapter does not expect the
+// fragment so we remove it before the lookup.
+func init() {
+	finder := message.DefaultSchemaDocFinder
+	message.DefaultSchemaDocFinder = func(source string) ([]byte, error) {
+		if i := strings.Index(source, "#"); i >= 0 {
+			source = source[:i]
+		}
+		return finder(source)
+	}
+}
+
+// setUpValidator installs the JSON Schema validator.
+func setUpValidator(mode validationMode) (err error) {
+	if mode == validationModeDisabled {
+		validator = message.NoOpValidator{}
+		log.Printf("[WARNING] JSON Schema validator is disabled.")
+		return nil
+	}
+	if validator, err = message.NewValidator(); err != nil {
+		return err
+	}
+	log.Printf("JSON Schema validator installed successfully (mode=%s).", mode)
+	return nil
+}
+
+// validateMessage validates the header and the body of a message against the
+// RDSS schemas. A message that can't be decoded is reported as a single issue.
+func validateMessage(blob []byte) []validationIssue {
+	msg := &message.Message{}
+	if err := json.Unmarshal(blob, msg); err != nil {
+		return []validationIssue{{Field: "(root)", Description: err.Error()}}
+	}
+	res, err := validator.Validate(msg)
+	if err != nil {
+		return []validationIssue{{Field: "(root)", Description: err.Error()}}
+	}
+	if validation != validationModeDisabled {
+		message.ValidateVersion(msg.MessageHeader.Version, res)
+	}
+	issues := []validationIssue{}
+	for _, item := range res.Errors() {
+		issues = append(issues, validationIssue{
+			Field:       item.Field(),
+			Description: item.Description(),
+		})
+	}
+	return issues
+}
+
+// messageType returns the type of an encoded message or an empty string if
+// it can't be determined.
+func messageType(blob []byte) string {
+	msg := struct {
+		MessageHeader struct {
+			MessageType string `json:"messageType"`
+		} `json:"messageHeader"`
+	}{}
+	if err := json.Unmarshal(blob, &msg); err != nil {
+		return ""
+	}
+	return msg.MessageHeader.MessageType
+}
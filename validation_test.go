@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JiscRDSS/rdss-archivematica-channel-adapter/broker/message"
+)
+
+// composeWithFile returns a MetadataCreate message with a single file built
+// like buildFile does.
+func composeWithFile(t *testing.T, sums []checksum) []byte {
+	m, err := composeMessage(message.MessageTypeMetadataCreate.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcr, err := m.MetadataCreateRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	info := objectInfo{Size: 42, Created: now, Modified: now}
+	mcr.ObjectFile = []message.File{
+		*createFile(message.NewUUID().String(), "s3://mybucket/foo.txt", "foo.txt", sums, info),
+	}
+	blob, err := encodeMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob
+}
+
+func TestComposedMessageValidation(t *testing.T) {
+	if err := setUpValidator(validationModeStrict); err != nil {
+		t.Fatal(err)
+	}
+	defer func(mode validationMode) { validation = mode }(validation)
+
+	tests := []struct {
+		name   string
+		sums   []checksum
+		issues []string // Fields reported by the validator.
+	}{
+		{
+			name:   "without checksums",
+			issues: []string{"fileChecksum"},
+		},
+		{
+			name: "with checksums",
+			sums: []checksum{{Type: message.ChecksumTypeEnum_md5, Value: "d41d8cd98f00b204e9800998ecf8427e"}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			validation = validationModeStrict
+			issues := validateMessage(composeWithFile(t, tc.sums))
+			if len(issues) != len(tc.issues) {
+				t.Fatalf("got %d issue(s), want %d: %v", len(issues), len(tc.issues), issues)
+			}
+			for i, issue := range issues {
+				if !strings.Contains(issue.Field, tc.issues[i]) {
+					t.Errorf("issue #%d is about %s, want %s", i, issue.Field, tc.issues[i])
+				}
+			}
+		})
+	}
+}
+
+// The message generated with the default flags, i.e. without checksums, must
+// be sendable with the default validation mode.
+func TestDefaultMessageIsSendable(t *testing.T) {
+	if err := setUpValidator(defaultValidationMode); err != nil {
+		t.Fatal(err)
+	}
+	defer func(mode validationMode, c *connection, name *string) {
+		validation, current, publisherName = mode, c, name
+	}(validation, current, publisherName)
+	name := "stdout"
+	validation, current, publisherName = defaultValidationMode, &connection{publisher: &stdoutPublisher{}}, &name
+
+	resp, err := publishMessage(context.Background(), composeWithFile(t, nil), true, false)
+	if err != nil {
+		t.Fatalf("the message was not sent: %s", err)
+	}
+	if len(resp.Issues) == 0 {
+		t.Errorf("the issues of the message were not reported")
+	}
+}